@@ -0,0 +1,268 @@
+package bibsin
+
+import (
+	"fmt"
+	"strings"
+)
+
+// InheritMode selects the rules by which a record inherits fields from the
+// entry named in its crossref field.
+type InheritMode int8
+
+const (
+	// InheritBibLaTeX follows the default inheritance setup of BibLaTeX:
+	// the title of a parent book becomes the booktitle of a chapter, that
+	// of a multi-volume work becomes the maintitle of a volume and so on.
+	InheritBibLaTeX InheritMode = iota
+	// InheritBibTeX copies the fields of the parent that the child lacks
+	// under the same name.
+	InheritBibTeX
+)
+
+// noInherit lists the fields that are never inherited.
+var noInherit = map[string]bool{
+	"crossref": true, "xref": true, "xdata": true, "ids": true,
+	"entryset": true, "entrysubtype": true, "execute": true, "label": true,
+	"options": true, "presort": true, "related": true, "relatedoptions": true,
+	"relatedstring": true, "relatedtype": true, "shorthand": true,
+	"shorthandintro": true, "sortkey": true,
+}
+
+// inheritRule maps the fields of a parent of one of the parents types to
+// those of a child of one of the children types. An empty target means
+// the field is not inherited.
+type inheritRule struct {
+	parents, children string
+	fields            [][2]string
+}
+
+var (
+	authorFields  = [][2]string{{"author", "author"}, {"author", "bookauthor"}}
+	mainFields    = titleFields("main")
+	bookFields    = titleFields("book")
+	journalFields = titleFields("journal")
+)
+
+func titleFields(prefix string) [][2]string {
+	return [][2]string{
+		{"title", prefix + "title"},
+		{"subtitle", prefix + "subtitle"},
+		{"titleaddon", prefix + "titleaddon"},
+		{"shorttitle", ""},
+		{"sorttitle", ""},
+		{"indextitle", ""},
+		{"indexsorttitle", ""},
+	}
+}
+
+// biblatexRules is the default inheritance setup of BibLaTeX.
+var biblatexRules = []inheritRule{
+	{"mvbook book", "inbook bookinbook suppbook", authorFields},
+	{"mvbook", "book inbook bookinbook suppbook", mainFields},
+	{"mvcollection mvreference", "collection reference incollection inreference suppcollection", mainFields},
+	{"mvproceedings", "proceedings inproceedings", mainFields},
+	{"book", "inbook bookinbook suppbook", bookFields},
+	{"collection reference", "incollection inreference suppcollection", bookFields},
+	{"proceedings", "inproceedings", bookFields},
+	{"periodical", "article suppperiodical", journalFields},
+}
+
+func hasWord(list, word string) bool {
+	for _, w := range strings.Fields(list) {
+		if strings.EqualFold(w, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// targets returns the names under which a child of type child inherits the
+// field name of a parent of type parent.
+func (mode InheritMode) targets(parent, child, name string) []string {
+	name = strings.ToLower(name)
+	if noInherit[name] {
+		return nil
+	}
+	if mode == InheritBibTeX {
+		return []string{name}
+	}
+	var res []string
+	found := false
+	for _, rule := range biblatexRules {
+		if !hasWord(rule.parents, parent) || !hasWord(rule.children, child) {
+			continue
+		}
+		for _, m := range rule.fields {
+			if m[0] == name {
+				found = true
+				if m[1] != "" {
+					res = append(res, m[1])
+				}
+			}
+		}
+	}
+	if !found {
+		return []string{name}
+	}
+	return res
+}
+
+// Resolve works out the fields each record of f inherits from the entries
+// named in its xdata and crossref fields, following the rules of mode for
+// crossref; xdata entries pass on their fields under the same name. It also
+// checks that the entries named in xref fields exist. Entries are looked up
+// by key regardless of case and may themselves inherit fields.
+//
+// The records are not changed: the inherited fields are returned by
+// Record.Inherited and Record.ResolvedField and written by Print and the
+// exporters on request. A field of the record itself takes precedence over
+// one from its xdata entries, which takes precedence over one from its
+// crossref entry. Missing entries and cycles are returned as ParseErrors.
+func (f *File) Resolve(mode InheritMode) error {
+	rs := &resolver{
+		file:  f,
+		mode:  mode,
+		byKey: make(map[string]*Record, len(f.Records)),
+		state: make(map[*Record]int8, len(f.Records)),
+	}
+	for _, rec := range f.Records {
+		if k := strings.ToLower(rec.key); rs.byKey[k] == nil {
+			rs.byKey[k] = rec
+		}
+	}
+	for _, rec := range f.Records {
+		rs.resolve(rec)
+	}
+	if len(rs.errs) > 0 {
+		return rs.errs
+	}
+	return nil
+}
+
+type resolver struct {
+	file  *File
+	mode  InheritMode
+	byKey map[string]*Record
+	state map[*Record]int8 // resolving or resolved
+	errs  ParseErrors
+}
+
+const (
+	resolving = iota + 1
+	resolved
+)
+
+func (rs *resolver) resolve(rec *Record) {
+	if rs.state[rec] != 0 {
+		return
+	}
+	rs.state[rec] = resolving
+	rec.inherited = nil
+	for _, key := range strings.Split(rec.ownField("xdata"), ",") {
+		if parent := rs.parent(rec, "xdata", key); parent != nil {
+			for _, fld := range parent.allFields() {
+				if !noInherit[strings.ToLower(fld.key)] {
+					rec.inherit(fld, fld.key)
+				}
+			}
+		}
+	}
+	if parent := rs.parent(rec, "crossref", rec.ownField("crossref")); parent != nil {
+		for _, fld := range parent.allFields() {
+			for _, name := range rs.mode.targets(parent.value, rec.value, fld.key) {
+				rec.inherit(fld, name)
+			}
+		}
+	}
+	for _, key := range strings.Split(rec.ownField("xref"), ",") {
+		rs.parent(rec, "xref", key)
+	}
+	rs.state[rec] = resolved
+}
+
+// parent returns the resolved entry named key in field name of rec, or nil
+// if there is none.
+func (rs *resolver) parent(rec *Record, name, key string) *Record {
+	key = strings.TrimSpace(key)
+	if key == "" {
+		return nil
+	}
+	parent := rs.byKey[strings.ToLower(key)]
+	var msg string
+	switch {
+	case parent == nil:
+		msg = fmt.Sprintf("%s entry %s not found", name, key)
+	case name == "xref":
+		return parent
+	case rs.state[parent] == resolving:
+		msg = fmt.Sprintf("%s entry %s forms a cycle", name, key)
+	default:
+		rs.resolve(parent)
+		return parent
+	}
+	pos := rec.span.Start
+	if fld := rec.lookup(name); fld != nil {
+		pos = fld.valueSpan.Start
+	}
+	rs.errs = append(rs.errs, &ParseError{
+		File:   rs.file.name,
+		Line:   pos.Line,
+		Column: pos.Column,
+		Offset: pos.Offset,
+		Key:    rec.key,
+		Msg:    msg,
+	})
+	return nil
+}
+
+// ownField returns the value of the field name of rec itself regardless
+// of case.
+func (rec *Record) ownField(name string) string {
+	if fld := rec.lookup(name); fld != nil {
+		return fld.value
+	}
+	return ""
+}
+
+// allFields returns the fields of rec followed by those it inherits.
+func (rec *Record) allFields() []Field {
+	if len(rec.inherited) == 0 {
+		return rec.fields
+	}
+	return append(rec.fields[:len(rec.fields):len(rec.fields)], rec.inherited...)
+}
+
+// inherit adds fld under name to the inherited fields of rec unless rec
+// already has a field of that name.
+func (rec *Record) inherit(fld Field, name string) {
+	if rec.lookup(name) != nil {
+		return
+	}
+	for _, f := range rec.inherited {
+		if strings.EqualFold(f.key, name) {
+			return
+		}
+	}
+	fld.key, fld.syn = name, nil
+	rec.inherited = append(rec.inherited, fld)
+}
+
+// Inherited returns the fields that rec inherits from other entries as
+// found by the last call to File.Resolve.
+func (rec *Record) Inherited() []Field {
+	return rec.inherited
+}
+
+// ResolvedField returns the value of the field fieldName of rec or, if rec
+// has no such field, the value it inherits.
+func (rec *Record) ResolvedField(fieldName string) string {
+	if fld := rec.lookup(fieldName); fld != nil {
+		return fld.value
+	}
+	for _, fld := range rec.inherited {
+		if strings.EqualFold(fld.key, fieldName) {
+			return fld.value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,217 @@
+package bibsin
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// BibLaTeX writes dates in the date, urldate, eventdate and origdate
+// fields in the Extended Date/Time Format (EDTF) of ISO 8601-2:
+//
+//	2012               a year
+//	2012-11, 2012-21   a month, or a season (21 to 24: spring to winter)
+//	2012-11-06         a day; a time of day after T is ignored
+//	-0044              a year before the common era
+//	2012~ 2012? 2012%  approximate (circa), uncertain, or both
+//	199X, 19XX         a decade or a century, read as 1990/1999, 1900/1999
+//	1998-05/2001       a range
+//	1998/, ../2001     ranges open at the end or at the start
+
+// DatePrecision tells which parts of a DatePart are known.
+type DatePrecision int8
+
+const (
+	PrecisionYear DatePrecision = iota
+	PrecisionMonth
+	PrecisionDay
+)
+
+// DatePart is a single date, known to the year, month or day.
+type DatePart struct {
+	Year       int // negative before the common era
+	Month, Day int // 0 when not known; Month is 21 to 24 for a season
+	Precision  DatePrecision
+	Circa      bool // approximate: ~ or %
+	Uncertain  bool // uncertain: ? or %
+}
+
+// Date is a BibLaTeX date. A single date has the same Start and End; a
+// range has different ones unless one of them is open.
+type Date struct {
+	Start, End DatePart
+	IsRange    bool
+	// OpenStart and OpenEnd tell that the range has no start or no end.
+	// The missing part is then zero.
+	OpenStart, OpenEnd bool
+}
+
+// ParseDate parses a date in the EDTF form used by BibLaTeX.
+func ParseDate(s string) (Date, error) {
+	var d Date
+	s = strings.TrimSpace(s)
+	start, end, isRange := strings.Cut(s, "/")
+	var ok bool
+	if isRange && (start == "" || start == "..") {
+		d.OpenStart = true
+	} else if d.Start, d.End, ok = parseDatePart(start); !ok {
+		return Date{}, fmt.Errorf("invalid date %q", s)
+	}
+	if isRange {
+		if end == "" || end == ".." {
+			d.OpenEnd, d.End = true, DatePart{}
+		} else if _, d.End, ok = parseDatePart(end); !ok {
+			return Date{}, fmt.Errorf("invalid date %q", s)
+		}
+	}
+	switch {
+	case d.OpenStart && d.OpenEnd:
+		return Date{}, fmt.Errorf("invalid date %q: open at both ends", s)
+	case !d.OpenStart && !d.OpenEnd && d.End.before(d.Start):
+		return Date{}, fmt.Errorf("invalid date %q: ends before it starts", s)
+	}
+	d.IsRange = isRange || d.Start != d.End
+	return d, nil
+}
+
+// parseDatePart parses a date that is not a range. A year with unspecified
+// digits, such as 199X, gives the first and last years it stands for; other
+// dates are returned twice.
+func parseDatePart(s string) (lo, hi DatePart, ok bool) {
+	var p DatePart
+	switch {
+	case strings.HasSuffix(s, "%"):
+		p.Circa, p.Uncertain = true, true
+	case strings.HasSuffix(s, "~"):
+		p.Circa = true
+	case strings.HasSuffix(s, "?"):
+		p.Uncertain = true
+	}
+	if p.Circa || p.Uncertain {
+		s = s[:len(s)-1]
+	}
+	if i := strings.IndexByte(s, 'T'); i >= 0 {
+		s = s[:i]
+	}
+	neg := strings.HasPrefix(s, "-")
+	parts := strings.Split(strings.TrimPrefix(s, "-"), "-")
+	year := parts[0]
+	digits := strings.TrimRight(year, "X")
+	if len(parts) > 3 || len(year) != 4 || digits != "" && !isDigits(digits) {
+		return lo, hi, false
+	}
+	unspecified := len(year) - len(digits)
+	if unspecified > 0 && (neg || len(parts) > 1 || unspecified > 2) {
+		return lo, hi, false
+	}
+	p.Year, _ = strconv.Atoi(digits + strings.Repeat("0", unspecified))
+	if neg {
+		p.Year = -p.Year
+	}
+	if len(parts) > 1 && parts[1] != "XX" {
+		m, err := strconv.Atoi(parts[1])
+		if err != nil || len(parts[1]) != 2 || !(m >= 1 && m <= 12 || m >= 21 && m <= 24) {
+			return lo, hi, false
+		}
+		p.Month, p.Precision = m, PrecisionMonth
+	}
+	if len(parts) > 2 && parts[2] != "XX" {
+		d, err := strconv.Atoi(parts[2])
+		if err != nil || len(parts[2]) != 2 || p.Month == 0 || p.Month > 12 || d < 1 || d > daysIn(p.Year, p.Month) {
+			return lo, hi, false
+		}
+		p.Day, p.Precision = d, PrecisionDay
+	}
+	lo, hi = p, p
+	if unspecified > 0 {
+		n, _ := strconv.Atoi("1" + strings.Repeat("0", unspecified))
+		hi.Year = lo.Year + n - 1
+	}
+	return lo, hi, true
+}
+
+func daysIn(year, month int) int {
+	return time.Date(year, time.Month(month)+1, 0, 0, 0, 0, 0, time.UTC).Day()
+}
+
+// before reports whether p comes before q, comparing the parts that both
+// have.
+func (p DatePart) before(q DatePart) bool {
+	switch {
+	case p.Year != q.Year:
+		return p.Year < q.Year
+	case p.Month == 0 || q.Month == 0 || p.Month == q.Month:
+		return p.Day != 0 && q.Day != 0 && p.Day < q.Day
+	}
+	return p.Month < q.Month
+}
+
+// String returns p in EDTF form.
+func (p DatePart) String() string {
+	var sb strings.Builder
+	if p.Year < 0 {
+		sb.WriteByte('-')
+	}
+	fmt.Fprintf(&sb, "%04d", max(p.Year, -p.Year))
+	if p.Precision >= PrecisionMonth {
+		fmt.Fprintf(&sb, "-%02d", p.Month)
+	}
+	if p.Precision >= PrecisionDay {
+		fmt.Fprintf(&sb, "-%02d", p.Day)
+	}
+	switch {
+	case p.Circa && p.Uncertain:
+		sb.WriteByte('%')
+	case p.Circa:
+		sb.WriteByte('~')
+	case p.Uncertain:
+		sb.WriteByte('?')
+	}
+	return sb.String()
+}
+
+// String returns d in EDTF form.
+func (d Date) String() string {
+	if !d.IsRange {
+		return d.Start.String()
+	}
+	start, end := "..", ""
+	if !d.OpenStart {
+		start = d.Start.String()
+	}
+	if !d.OpenEnd {
+		end = d.End.String()
+	}
+	return start + "/" + end
+}
+
+// first returns the start of d or, if it is open, its end.
+func (d Date) first() DatePart {
+	if d.OpenStart {
+		return d.End
+	}
+	return d.Start
+}
+
+// DateField parses the BibLaTeX date in the field name of rec, such as
+// date, urldate, eventdate or origdate.
+func (rec *Record) DateField(name string) (Date, error) {
+	s := rec.firstField(name)
+	if s == "" {
+		return Date{}, fmt.Errorf("no %s field", name)
+	}
+	return ParseDate(s)
+}
+
+// yearText returns the year field of rec as written or, if there is none,
+// the year of its date.
+func (rec *Record) yearText() string {
+	if s := rec.Field("year"); s != "" {
+		return s
+	}
+	if y, ok := rec.Year(); ok {
+		return strconv.Itoa(y)
+	}
+	return ""
+}
@@ -0,0 +1,223 @@
+package bibsin
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// linkedSets groups the records of files into sets of records linked,
+// directly or through other records, by a shared identifier if
+// opts.Identifiers is set, then by their similarity if opts.Fuzzy is set or
+// by equal index entries otherwise. Identifiers come first: a set never
+// holds two records with identifiers of the same kind unless they share
+// one, whatever their other fields. In fuzzy mode, the similarities of the
+// pairs of records that were linked directly are returned as well.
+func linkedSets(files []*File, index func(*Record) string, opts DedupOptions) (DedupMap, []DupPair) {
+	var infos []NodeInfo
+	for _, f := range files {
+		for _, rec := range f.Records {
+			infos = append(infos, NodeInfo{rec, f})
+		}
+	}
+	keys := make([]string, len(infos))
+	var fuzzy []fuzzyRecord
+	ids := make([][]string, len(infos))
+	sets := newLinker(len(infos))
+	for i, info := range infos {
+		if opts.Fuzzy {
+			fuzzy = append(fuzzy, newFuzzyRecord(info, opts))
+			keys[i] = fuzzy[i].key()
+		} else {
+			keys[i] = index(info.Node)
+		}
+		if opts.Identifiers {
+			ids[i] = identifiers(info.Node)
+			sets.setIdentifiers(i, ids[i])
+		}
+	}
+	var pairs []DupPair
+	var pairOf []int // a record of each pair, to find its set
+	addPair := func(i, j int) {
+		title, authors, _ := fuzzy[i].compare(&fuzzy[j], opts)
+		pairs = append(pairs, DupPair{
+			A:       infos[i],
+			B:       infos[j],
+			Title:   title,
+			Authors: authors,
+			Score:   (title + authors) / 2,
+		})
+		pairOf = append(pairOf, i)
+	}
+	first := make(map[string]int) // the first record with each identifier
+	for i := range infos {
+		for _, id := range ids[i] {
+			if j, ok := first[id]; !ok {
+				first[id] = i
+			} else if sets.link(j, i) && opts.Fuzzy {
+				addPair(j, i)
+			}
+		}
+	}
+	if opts.Fuzzy {
+		blocks := newFuzzyBlocks(fuzzy, opts)
+		for i := range fuzzy {
+			for _, j := range blocks.candidates(i) {
+				if sets.find(i) == sets.find(j) {
+					continue
+				}
+				if fuzzy[j].similar(&fuzzy[i], opts) && sets.link(j, i) {
+					addPair(j, i)
+				}
+			}
+		}
+	} else {
+		byKey := make(map[string][]int, len(infos))
+		for i, k := range keys {
+			for _, j := range byKey[k] {
+				sets.link(j, i)
+			}
+			byKey[k] = append(byKey[k], i)
+		}
+	}
+
+	dupSet := make(DedupMap, len(infos))
+	setKeys := make(map[int]string) // the key of the set of each root
+	for _, members := range sets.sets() {
+		key := keys[members[0]]
+		for n, base := 2, key; dupSet[key] != nil; n++ {
+			key = fmt.Sprintf("%s #%d", base, n)
+		}
+		setKeys[sets.find(members[0])] = key
+		for _, i := range members {
+			dupSet[key] = append(dupSet[key], infos[i])
+		}
+	}
+	for k, i := range pairOf {
+		pairs[k].Set = setKeys[sets.find(i)]
+	}
+	return dupSet, pairs
+}
+
+// identifiers returns the DOI, PMID and ISBN of rec that it has, each
+// prefixed by its kind, e.g. "doi:10.1000/xyz". The ISBN of a chapter or a
+// paper in proceedings is that of the book, which it shares with the other
+// chapters, so it is left out.
+func identifiers(rec *Record) []string {
+	var ids []string
+	for _, id := range [...]struct{ kind, value string }{
+		{"doi", rec.DOI()},
+		{"pmid", rec.PMID()},
+		{"isbn", rec.ISBN()},
+	} {
+		if id.kind == "isbn" && partOfBook[strings.ToLower(rec.value)] {
+			continue
+		}
+		if id.value != "" {
+			ids = append(ids, id.kind+":"+id.value)
+		}
+	}
+	return ids
+}
+
+// partOfBook lists the entry types of works published in a book.
+var partOfBook = map[string]bool{"inbook": true, "incollection": true, "inproceedings": true}
+
+// linker is a unionFind that keeps, for the root of each set, the
+// identifiers of its records by kind, and does not join sets with
+// different identifiers of the same kind.
+type linker struct {
+	unionFind
+	ids []map[string][]string
+}
+
+func newLinker(n int) *linker {
+	return &linker{newUnionFind(n), make([]map[string][]string, n)}
+}
+
+// setIdentifiers records the identifiers of i, which must not be linked
+// yet.
+func (l *linker) setIdentifiers(i int, ids []string) {
+	for _, id := range ids {
+		kind, v, _ := strings.Cut(id, ":")
+		if l.ids[i] == nil {
+			l.ids[i] = make(map[string][]string)
+		}
+		l.ids[i][kind] = append(l.ids[i][kind], v)
+	}
+}
+
+// link joins the sets of i and j unless they are the same set or both
+// have identifiers of a kind and share none of them, and reports whether
+// it joined them.
+func (l *linker) link(i, j int) bool {
+	ri, rj := l.find(i), l.find(j)
+	if ri == rj {
+		return false
+	}
+	for kind, values := range l.ids[ri] {
+		if other, ok := l.ids[rj][kind]; ok && !slices.ContainsFunc(values, func(v string) bool { return slices.Contains(other, v) }) {
+			return false
+		}
+	}
+	l.union(ri, rj)
+	root, other := ri, rj
+	if l.find(ri) != ri {
+		root, other = rj, ri
+	}
+	if l.ids[root] == nil {
+		l.ids[root] = make(map[string][]string)
+	}
+	for kind, values := range l.ids[other] {
+		for _, v := range values {
+			if !slices.Contains(l.ids[root][kind], v) {
+				l.ids[root][kind] = append(l.ids[root][kind], v)
+			}
+		}
+	}
+	l.ids[other] = nil
+	return true
+}
+
+// unionFind holds disjoint sets of the integers from 0 to n-1.
+type unionFind []int
+
+func newUnionFind(n int) unionFind {
+	u := make(unionFind, n)
+	for i := range u {
+		u[i] = i
+	}
+	return u
+}
+
+func (u unionFind) find(i int) int {
+	for u[i] != i {
+		u[i] = u[u[i]]
+		i = u[i]
+	}
+	return i
+}
+
+func (u unionFind) union(i, j int) {
+	if ri, rj := u.find(i), u.find(j); ri != rj {
+		u[max(ri, rj)] = min(ri, rj)
+	}
+}
+
+// sets returns the sets in the order of their smallest members, each in
+// increasing order.
+func (u unionFind) sets() [][]int {
+	var sets [][]int
+	pos := make(map[int]int) // position of the set of each root in sets
+	for i := range u {
+		r := u.find(i)
+		k, ok := pos[r]
+		if !ok {
+			k = len(sets)
+			pos[r] = k
+			sets = append(sets, nil)
+		}
+		sets[k] = append(sets[k], i)
+	}
+	return sets
+}
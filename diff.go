@@ -0,0 +1,134 @@
+package bibsin
+
+import (
+	"maps"
+	"slices"
+	"strings"
+)
+
+// Clone returns a copy of rec that shares nothing that can be changed with
+// rec.
+func (rec *Record) Clone() *Record {
+	c := *rec
+	c.fields = slices.Clone(rec.fields)
+	c.inherited = slices.Clone(rec.inherited)
+	return &c
+}
+
+// Clone returns a copy of f whose records are clones of those of f.
+func (f *File) Clone() *File {
+	c := &File{
+		Records:    make([]*Record, len(f.Records)),
+		name:       f.name,
+		macros:     maps.Clone(f.macros),
+		macroNames: slices.Clone(f.macroNames),
+	}
+	clones := make(map[*Record]*Record, len(f.Records))
+	for i, rec := range f.Records {
+		c.Records[i] = rec.Clone()
+		clones[rec] = c.Records[i]
+	}
+	c.nodes = make([]Node, len(f.nodes))
+	for i, n := range f.nodes {
+		if rec, ok := n.(*Record); ok && clones[rec] != nil {
+			n = clones[rec]
+		} else if ok {
+			n = rec.Clone()
+		}
+		c.nodes[i] = n
+	}
+	return c
+}
+
+// normValue returns the form of a value used to compare records: white
+// space collapsed and case folded.
+func normValue(s string) string {
+	return strings.ToLower(strings.Join(strings.Fields(s), " "))
+}
+
+// fieldsByName groups the normalised values of the fields of rec by lower
+// case name.
+func (rec *Record) fieldsByName() map[string][]string {
+	m := make(map[string][]string, len(rec.fields))
+	for _, fld := range rec.fields {
+		name := strings.ToLower(fld.key)
+		m[name] = append(m[name], normValue(fld.value))
+	}
+	return m
+}
+
+// Equal reports whether rec and other hold the same bibliographic data:
+// the same type, key and fields, regardless of the case of names and of
+// values, of white space in values, of how values are delimited and of the
+// order of fields, except among repeated ones.
+func (rec *Record) Equal(other *Record) bool {
+	if rec == nil || other == nil {
+		return rec == other
+	}
+	if !strings.EqualFold(rec.value, other.value) || !strings.EqualFold(rec.key, other.key) {
+		return false
+	}
+	return maps.EqualFunc(rec.fieldsByName(), other.fieldsByName(), slices.Equal[[]string])
+}
+
+// DiffKind is the kind of a FieldDiff.
+type DiffKind int8
+
+const (
+	FieldAdded DiffKind = iota
+	FieldRemoved
+	FieldChanged
+)
+
+func (k DiffKind) String() string {
+	return [...]string{"added", "removed", "changed"}[k]
+}
+
+// FieldDiff is a difference in one field between two records.
+type FieldDiff struct {
+	Name     string // as written in the record that has the field
+	Kind     DiffKind
+	Old, New string // the values in the first and second record
+}
+
+// DiffRecords lists the fields that were added, removed or changed from a
+// to b. Values are compared as by Record.Equal; the n-th occurrence of a
+// repeated field is compared with the n-th occurrence in the other record.
+// Differences are listed in the order of the fields of a, then of those
+// only in b.
+func DiffRecords(a, b *Record) []FieldDiff {
+	var diffs []FieldDiff
+	seen := make(map[string]int) // occurrences of each name so far
+	for _, fld := range a.fields {
+		name := strings.ToLower(fld.key)
+		n := seen[name]
+		seen[name]++
+		others := occurrences(b, name)
+		switch {
+		case n >= len(others):
+			diffs = append(diffs, FieldDiff{Name: fld.key, Kind: FieldRemoved, Old: fld.value})
+		case normValue(fld.value) != normValue(others[n].value):
+			diffs = append(diffs, FieldDiff{Name: fld.key, Kind: FieldChanged, Old: fld.value, New: others[n].value})
+		}
+	}
+	for _, fld := range b.fields {
+		name := strings.ToLower(fld.key)
+		if seen[name] > 0 {
+			seen[name]--
+			continue
+		}
+		diffs = append(diffs, FieldDiff{Name: fld.key, Kind: FieldAdded, New: fld.value})
+	}
+	return diffs
+}
+
+// occurrences returns the fields of rec called name, ignoring case.
+func occurrences(rec *Record, name string) []Field {
+	var res []Field
+	for _, fld := range rec.fields {
+		if strings.EqualFold(fld.key, name) {
+			res = append(res, fld)
+		}
+	}
+	return res
+}
@@ -9,16 +9,18 @@ package bibsin
 //               |  Comment
 //               |  String
 //               |  Preamble
-// Comment      ::= "comment" [^\n]* \n                -- ignored
-// String       ::= "string" '{' Field* '}'             -- not handled 
-// Preamble     ::= "preamble" '{' .* '}'         -- not handled
+// Comment      ::= "comment" [^\n]* \n
+//               |  "comment" '{' .* '}'              -- (balanced)
+// String       ::= "string" '{' Field '}'
+// Preamble     ::= "preamble" '{' .* '}'             -- (balanced)
 // Record       ::= Type '{' Key ',' Field* '}'
-//               |  Type '(' Key ',' Field* ')' -- not handled
+//               |  Type '(' Key ',' Field* ')'
 // Type         ::= Name
 // Key          ::= Name
-// Field        ::= Name '=' Value
+// Field        ::= Name '=' Value ('#' Value)*
 // Name         ::= [^\s\"#%'(){}]*
-// Value        ::= [0-9]+						-- not handled
-//               |  '"' ([^'"']|\\'"')* '"'  -- not handled 
+// Value        ::= [0-9]+
+//               |  Name                                -- macro
+//               |  '"' .* '"'                         -- (balanced braces)
 //               |  '{' .* '}'                         -- (balanced)
 
@@ -0,0 +1,167 @@
+package bibsin
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// Encoding is the character encoding of an input. Whatever the encoding,
+// Parse produces UTF-8 text; positions and offsets refer to that text.
+type Encoding int8
+
+const (
+	// EncodingAuto reads UTF-8, decoding any byte that is not part of a
+	// valid UTF-8 sequence as Windows-1252.
+	EncodingAuto Encoding = iota
+	UTF8                  // invalid sequences become U+FFFD
+	Latin1                // ISO 8859-1
+	Windows1252
+	UTF16LE
+	UTF16BE
+)
+
+var encodingNames = [...]string{
+	EncodingAuto: "auto",
+	UTF8:         "utf-8",
+	Latin1:       "latin1",
+	Windows1252:  "windows-1252",
+	UTF16LE:      "utf-16le",
+	UTF16BE:      "utf-16be",
+}
+
+func (enc Encoding) String() string {
+	return encodingNames[enc]
+}
+
+var boms = []struct {
+	bom []byte
+	enc Encoding
+}{
+	{[]byte{0xEF, 0xBB, 0xBF}, UTF8},
+	{[]byte{0xFF, 0xFE}, UTF16LE},
+	{[]byte{0xFE, 0xFF}, UTF16BE},
+}
+
+// cp1252 maps the bytes 0x80 to 0x9F of Windows-1252, which is otherwise
+// identical to Latin-1. The five unassigned bytes map to the C1 controls
+// as in Latin-1.
+var cp1252 = [32]rune{
+	'€', 0x81, '‚', 'ƒ', '„', '…', '†', '‡', 'ˆ', '‰', 'Š', '‹', 'Œ', 0x8D, 'Ž', 0x8F,
+	0x90, '‘', '’', '“', '”', '•', '–', '—', '˜', '™', 'š', '›', 'œ', 0x9D, 'ž', 'Ÿ',
+}
+
+func decode1252(b byte) rune {
+	if b >= 0x80 && b < 0xA0 {
+		return cp1252[b-0x80]
+	}
+	return rune(b)
+}
+
+// decoder converts its input to UTF-8.
+type decoder struct {
+	r   *bufio.Reader
+	enc Encoding
+	buf []byte // decoded text not yet returned
+	err error
+}
+
+// newDecoder returns a reader of the UTF-8 text of r, which is in encoding
+// enc. A byte order mark at the start of r is removed and overrides enc.
+func newDecoder(r io.Reader, enc Encoding) io.Reader {
+	br := bufio.NewReader(r)
+	for _, b := range boms {
+		if p, _ := br.Peek(len(b.bom)); bytes.Equal(p, b.bom) {
+			br.Discard(len(b.bom))
+			enc = b.enc
+			break
+		}
+	}
+	return &decoder{r: br, enc: enc}
+}
+
+func (d *decoder) Read(p []byte) (int, error) {
+	for len(d.buf) < len(p) && d.err == nil {
+		d.err = d.decode()
+	}
+	n := copy(p, d.buf)
+	d.buf = d.buf[:copy(d.buf, d.buf[n:])]
+	if n > 0 {
+		return n, nil
+	}
+	return 0, d.err
+}
+
+// decode appends the next character of the input to buf.
+func (d *decoder) decode() error {
+	switch d.enc {
+	case Latin1, Windows1252:
+		b, err := d.r.ReadByte()
+		if err != nil {
+			return err
+		}
+		r := rune(b)
+		if d.enc == Windows1252 {
+			r = decode1252(b)
+		}
+		d.buf = utf8.AppendRune(d.buf, r)
+		return nil
+	case UTF16LE, UTF16BE:
+		r1, err := d.unit()
+		if err != nil {
+			return err
+		}
+		if r1 < 0xD800 || r1 >= 0xDC00 {
+			// not the first half of a surrogate pair
+			if utf16.IsSurrogate(r1) {
+				r1 = utf8.RuneError
+			}
+			d.buf = utf8.AppendRune(d.buf, r1)
+			return nil
+		}
+		r2, err := d.unit()
+		if err != nil {
+			d.buf = utf8.AppendRune(d.buf, utf8.RuneError)
+			return err
+		}
+		if r := utf16.DecodeRune(r1, r2); r != utf8.RuneError {
+			d.buf = utf8.AppendRune(d.buf, r)
+			return nil
+		}
+		if utf16.IsSurrogate(r2) {
+			r2 = utf8.RuneError
+		}
+		d.buf = utf8.AppendRune(utf8.AppendRune(d.buf, utf8.RuneError), r2)
+		return nil
+	}
+	r, size, err := d.r.ReadRune()
+	if err != nil {
+		return err
+	}
+	if r == utf8.RuneError && size == 1 && d.enc == EncodingAuto {
+		d.r.UnreadRune()
+		b, _ := d.r.ReadByte()
+		r = decode1252(b)
+	}
+	d.buf = utf8.AppendRune(d.buf, r)
+	return nil
+}
+
+// unit reads a UTF-16 code unit. An odd byte at the end of the input
+// reads as U+FFFD.
+func (d *decoder) unit() (rune, error) {
+	var b [2]byte
+	n, err := io.ReadFull(d.r, b[:])
+	switch {
+	case n == 1:
+		return utf8.RuneError, nil
+	case err != nil:
+		return 0, err
+	}
+	if d.enc == UTF16LE {
+		return rune(b[0]) | rune(b[1])<<8, nil
+	}
+	return rune(b[0])<<8 | rune(b[1]), nil
+}
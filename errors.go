@@ -0,0 +1,35 @@
+package bibsin
+
+import "fmt"
+
+// ParseError describes a problem found by Parse.
+type ParseError struct {
+	File   string
+	Line   int
+	Column int
+	Offset int
+	Key    string // citation key of the record involved, if known
+	Msg    string
+}
+
+func (e *ParseError) Error() string {
+	s := fmt.Sprintf("%s:%d:%d: %s", e.File, e.Line, e.Column, e.Msg)
+	if e.Key != "" {
+		s += fmt.Sprintf(" (record %s)", e.Key)
+	}
+	return s
+}
+
+// ParseErrors lists the problems found by Parse in the order they were
+// found.
+type ParseErrors []*ParseError
+
+func (el ParseErrors) Error() string {
+	switch len(el) {
+	case 0:
+		return "no errors"
+	case 1:
+		return el[0].Error()
+	}
+	return fmt.Sprintf("%s (and %d more errors)", el[0], len(el)-1)
+}
@@ -10,12 +10,18 @@ import (
 // ExportTyp takes a deduplicated bib *File with fixed keys and types
 // and outputs several typ-formatted files ready for typesetting
 func ExportTyp(bib *File, outDirName string) error {
+	return ExportTypWith(bib, outDirName, ExportOptions{})
+}
+
+// ExportTypWith is like ExportTyp but uses the given options.
+func ExportTypWith(bib *File, outDirName string, opts ExportOptions) error {
 	files := Split(bib)
 	if len(files) == 0 {
 		return fmt.Errorf("nothign to export")
 	}
 	for name, f := range files {
-		_, _, err := Deduplicate([]*File{f}, []string{"year", "title"}, SetUnion)
+		_, _, err := DeduplicateWith([]*File{f}, []string{"year", "title"}, SetUnion,
+			DedupOptions{Duplicates: opts.Duplicates, DupSep: opts.DupSep})
 		if err != nil {
 			return err
 		}
@@ -34,7 +40,7 @@ func ExportTyp(bib *File, outDirName string) error {
 			secName = strings.Title(name)
 		}
 		saveWith(filepath.Join(outDirName, name+".typ"), func(w io.Writer) error {
-			return AsTyp(w, f, secName)
+			return AsTypWith(w, f, secName, opts)
 		})
 	}
 	return nil
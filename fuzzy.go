@@ -0,0 +1,335 @@
+package bibsin
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// Fuzzy deduplication compares records on their titles, authors and years,
+// and links the pairs that are similar enough; see linkedSets. Only the
+// records whose titles share a rare enough word are compared; see
+// fuzzyBlocks.
+
+const (
+	defaultTitleThreshold  = 0.9
+	defaultAuthorThreshold = 0.8
+	// wordThreshold is the Jaro-Winkler similarity from which two words
+	// of titles are taken as variants of one another, as are "randomised"
+	// and "randomized".
+	wordThreshold = 0.75
+)
+
+// DupPair is the similarity of two records that fuzzy deduplication linked
+// directly, by their similarity or a shared identifier; a set of n
+// duplicates has n-1 such pairs. Similarities range from 0 to 1, for equal
+// values.
+type DupPair struct {
+	Set     string // the key of the set in DedupReport.DuplicateSet
+	A, B    NodeInfo
+	Title   float64
+	Authors float64 // 1 if either record has no authors
+	Score   float64 // the mean of Title and Authors
+}
+
+// fuzzyRecord holds the normalised values of a record that are compared.
+type fuzzyRecord struct {
+	info    NodeInfo
+	title   string          // title tokens joined by spaces
+	words   []string        // title tokens without repeats, in order
+	tokens  map[string]bool // title tokens
+	authors map[string]bool // last name and first initial of each author
+	year    int
+	hasYear bool
+}
+
+func newFuzzyRecord(info NodeInfo, opts DedupOptions) fuzzyRecord {
+	rec := info.Node
+	words := strings.FieldsFunc(foldText(rec.MergedField("title", opts.Duplicates, opts.DupSep)), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	fr := fuzzyRecord{
+		info:    info,
+		title:   strings.Join(words, " "),
+		tokens:  make(map[string]bool, len(words)),
+		authors: make(map[string]bool),
+	}
+	for _, w := range words {
+		if !fr.tokens[w] {
+			fr.tokens[w] = true
+			fr.words = append(fr.words, w)
+		}
+	}
+	for _, p := range ParseNames(rec.MergedField("author", opts.Duplicates, opts.DupSep)) {
+		if p.IsOthers() {
+			continue
+		}
+		key := keyWord(foldText(p.Last))
+		if p.Initials != "" {
+			key += " " + strings.ToLower(p.Initials[:1])
+		}
+		fr.authors[key] = true
+	}
+	fr.year, fr.hasYear = rec.Year()
+	return fr
+}
+
+// foldText returns s decoded from LaTeX, in lower case and without
+// accents.
+func foldText(s string) string {
+	return strings.Map(func(r rune) rune {
+		if a, ok := accentOf[r]; ok {
+			r = a.base
+		}
+		return unicode.ToLower(r)
+	}, decodeLaTeX(s, false))
+}
+
+// compare returns the similarities of the titles and authors of a and b,
+// and whether they are duplicates.
+func (a *fuzzyRecord) compare(b *fuzzyRecord, opts DedupOptions) (title, authors float64, dup bool) {
+	title = dice(a.tokens, b.tokens)
+	if title >= 0.5 && title < 1 {
+		title = max(title, softDice(a.words, b.words))
+	}
+	authors = 1
+	if len(a.authors) > 0 && len(b.authors) > 0 {
+		authors = overlap(a.authors, b.authors)
+	}
+	dup = title >= opts.titleThreshold() && authors >= opts.authorThreshold()
+	if a.hasYear && b.hasYear && abs(a.year-b.year) > opts.YearTolerance {
+		dup = false
+	}
+	return title, authors, dup
+}
+
+// similar reports whether a and b are duplicates, as compare does, without
+// comparing their titles when their years or authors are too different.
+func (a *fuzzyRecord) similar(b *fuzzyRecord, opts DedupOptions) bool {
+	if a.hasYear && b.hasYear && abs(a.year-b.year) > opts.YearTolerance {
+		return false
+	}
+	if len(a.authors) > 0 && len(b.authors) > 0 && overlap(a.authors, b.authors) < opts.authorThreshold() {
+		return false
+	}
+	_, _, dup := a.compare(b, opts)
+	return dup
+}
+
+func (opts DedupOptions) titleThreshold() float64 {
+	if opts.TitleThreshold > 0 {
+		return opts.TitleThreshold
+	}
+	return defaultTitleThreshold
+}
+
+func (opts DedupOptions) authorThreshold() float64 {
+	if opts.AuthorThreshold > 0 {
+		return opts.AuthorThreshold
+	}
+	return defaultAuthorThreshold
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// dice returns the Dice coefficient of the sets a and b: twice the size of
+// their intersection over the sum of their sizes, or 0 if either is empty.
+func dice(a, b map[string]bool) float64 {
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+	return 2 * float64(common(a, b)) / float64(len(a)+len(b))
+}
+
+// overlap returns the size of the intersection of a and b over the size
+// of the smaller one, so that a list of authors cut short matches the full
+// list.
+func overlap(a, b map[string]bool) float64 {
+	return float64(common(a, b)) / float64(min(len(a), len(b)))
+}
+
+func common(a, b map[string]bool) int {
+	n := 0
+	for k := range a {
+		if b[k] {
+			n++
+		}
+	}
+	return n
+}
+
+// softDice returns the Dice coefficient of the words a and b of two titles
+// in which a word counts for its Jaro-Winkler similarity to the most
+// similar word of the other title, if that is at least wordThreshold, so
+// that spelling variants match but words that only share a few letters do
+// not.
+func softDice(a, b []string) float64 {
+	return (bestMatches(a, b) + bestMatches(b, a)) / float64(len(a)+len(b))
+}
+
+func bestMatches(a, b []string) float64 {
+	sum := 0.0
+	for _, w := range a {
+		best := 0.0
+		for _, v := range b {
+			best = max(best, jaroWinkler(w, v))
+		}
+		if best >= wordThreshold {
+			sum += best
+		}
+	}
+	return sum
+}
+
+// jaroWinkler returns the Jaro-Winkler similarity of s and t.
+func jaroWinkler(s, t string) float64 {
+	a, b := []rune(s), []rune(t)
+	if len(a) == 0 || len(b) == 0 {
+		if len(a) == len(b) {
+			return 1
+		}
+		return 0
+	}
+	window := max(len(a), len(b))/2 - 1
+	matchedA := make([]bool, len(a))
+	matchedB := make([]bool, len(b))
+	matches := 0
+	for i, r := range a {
+		for j := max(0, i-window); j < min(len(b), i+window+1); j++ {
+			if !matchedB[j] && b[j] == r {
+				matchedA[i], matchedB[j] = true, true
+				matches++
+				break
+			}
+		}
+	}
+	if matches == 0 {
+		return 0
+	}
+	transpositions, j := 0, 0
+	for i, r := range a {
+		if !matchedA[i] {
+			continue
+		}
+		for !matchedB[j] {
+			j++
+		}
+		if b[j] != r {
+			transpositions++
+		}
+		j++
+	}
+	m := float64(matches)
+	jaro := (m/float64(len(a)) + m/float64(len(b)) + (m-float64(transpositions)/2)/m) / 3
+	prefix := 0
+	for prefix < min(4, len(a), len(b)) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	return jaro + float64(prefix)*0.1*(1-jaro)
+}
+
+// key returns the key of a set of duplicates that starts with fr: its year
+// and title.
+func (fr *fuzzyRecord) key() string {
+	if fr.hasYear {
+		return fmt.Sprintf("%d %s", fr.year, fr.title)
+	}
+	return fr.title
+}
+
+// fuzzyBlocks finds the records that may be duplicates of a record, so that
+// fuzzy deduplication does not compare every pair. Titles whose words have
+// a Dice coefficient of at least d share at least d·n/(2-d) of the n words
+// of either one. With the words of each title ordered from the rarest, two
+// such titles thus share one of the first n-⌈d·n/(2-d)⌉+1 words of each,
+// their prefixes, and only records that do are compared.
+type fuzzyBlocks struct {
+	recs      []fuzzyRecord
+	df        map[string]int                 // the number of titles with each word
+	postings  map[string]map[blockYear][]int // the records with each word in their prefix
+	d         float64
+	tolerance int
+	seen      []int // the record, plus one, whose candidates last included each record
+}
+
+type blockYear struct {
+	year  int
+	known bool
+}
+
+func newFuzzyBlocks(recs []fuzzyRecord, opts DedupOptions) *fuzzyBlocks {
+	b := &fuzzyBlocks{
+		recs:     recs,
+		df:       make(map[string]int),
+		postings: make(map[string]map[blockYear][]int),
+		// titles with a Dice coefficient under 0.5 are compared by it
+		// alone; see compare
+		d:         min(0.5, opts.titleThreshold()),
+		tolerance: opts.YearTolerance,
+		seen:      make([]int, len(recs)),
+	}
+	for i := range recs {
+		for w := range recs[i].tokens {
+			b.df[w]++
+		}
+	}
+	return b
+}
+
+// candidates returns, in increasing order, the records before i that share
+// a word of their prefix with i and whose years are close enough to its
+// own, and adds i to the blocks.
+func (b *fuzzyBlocks) candidates(i int) []int {
+	fr := &b.recs[i]
+	var found []int
+	for _, w := range b.prefix(fr) {
+		byYear := b.postings[w]
+		if byYear == nil {
+			byYear = make(map[blockYear][]int)
+			b.postings[w] = byYear
+		}
+		for y, recs := range byYear {
+			if fr.hasYear && y.known && abs(fr.year-y.year) > b.tolerance {
+				continue
+			}
+			for _, j := range recs {
+				if b.seen[j] != i+1 {
+					b.seen[j] = i + 1
+					found = append(found, j)
+				}
+			}
+		}
+		y := blockYear{fr.year, fr.hasYear}
+		byYear[y] = append(byYear[y], i)
+	}
+	slices.Sort(found)
+	return found
+}
+
+// prefix returns the rarest words of the title of fr that a title similar
+// enough must share one of.
+func (b *fuzzyBlocks) prefix(fr *fuzzyRecord) []string {
+	words := make([]string, 0, len(fr.tokens))
+	for w := range fr.tokens {
+		words = append(words, w)
+	}
+	if len(words) == 0 {
+		return nil
+	}
+	slices.SortFunc(words, func(x, y string) int {
+		if b.df[x] != b.df[y] {
+			return b.df[x] - b.df[y]
+		}
+		return strings.Compare(x, y)
+	})
+	n := float64(len(words))
+	shared := int(math.Ceil(b.d*n/(2-b.d) - 1e-9))
+	return words[:len(words)-max(shared, 1)+1]
+}
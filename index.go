@@ -0,0 +1,130 @@
+package bibsin
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The key index of a File maps the lower case citation key of each record
+// to the position in Records of the first record with that key. It is built
+// on first use and rebuilt after the methods of File change the records or
+// when it may be out of date: the number of records changed, a key leads to
+// a record with another key or a key is not found. Records put into
+// Records directly and keys changed with Record.SetKey are thus noticed,
+// at the cost of a rebuild for each key that is not found.
+
+// keyIndex returns the index of f, rebuilding it if needed.
+func (f *File) keyIndex() map[string]int {
+	if f.index != nil && f.indexed == len(f.Records) {
+		return f.index
+	}
+	f.index = make(map[string]int, len(f.Records))
+	for i, rec := range f.Records {
+		k := strings.ToLower(rec.key)
+		if _, ok := f.index[k]; !ok {
+			f.index[k] = i
+		}
+	}
+	f.indexed = len(f.Records)
+	return f.index
+}
+
+// find returns the position in Records of the first record whose key is
+// key, ignoring case, or -1.
+func (f *File) find(key string) int {
+	k := strings.ToLower(key)
+	built := f.index == nil || f.indexed != len(f.Records)
+	i, ok := f.keyIndex()[k]
+	if ok && strings.EqualFold(f.Records[i].key, key) {
+		return i
+	}
+	if built {
+		return -1
+	}
+	f.index = nil // out of date
+	if i, ok = f.keyIndex()[k]; ok {
+		return i
+	}
+	return -1
+}
+
+// Lookup returns the record whose citation key is key, ignoring case as
+// BibTeX does, or nil. If several records share the key, the first one is
+// returned.
+func (f *File) Lookup(key string) *Record {
+	if i := f.find(key); i >= 0 {
+		return f.Records[i]
+	}
+	return nil
+}
+
+// Contains reports whether f has a record whose key is key, ignoring case.
+func (f *File) Contains(key string) bool {
+	return f.find(key) >= 0
+}
+
+// Remove removes the records whose key is key, ignoring case, and reports
+// whether there were any. The other records keep their order.
+func (f *File) Remove(key string) bool {
+	found := false
+	for i := f.find(key); i >= 0; i = f.find(key) {
+		f.removeNode(i)
+		f.Records = append(f.Records[:i], f.Records[i+1:]...)
+		f.index = nil
+		found = true
+	}
+	return found
+}
+
+// Replace puts rec in the place of the record whose key is key, ignoring
+// case, and reports whether there was one. rec may have another key.
+func (f *File) Replace(key string, rec *Record) bool {
+	i := f.find(key)
+	if i < 0 {
+		return false
+	}
+	if j := f.recordNode(i); j >= 0 {
+		f.nodes[j] = rec
+	}
+	f.Records[i] = rec
+	f.index = nil
+	return true
+}
+
+// Rename changes the key of the record whose key is oldKey to newKey. It
+// fails if there is no such record or if another record has newKey.
+func (f *File) Rename(oldKey, newKey string) error {
+	i := f.find(oldKey)
+	if i < 0 {
+		return fmt.Errorf("no record with key %s", oldKey)
+	}
+	if j := f.find(newKey); j >= 0 && j != i {
+		return fmt.Errorf("key %s is already used", newKey)
+	}
+	f.Records[i].key = newKey
+	f.index = nil
+	return nil
+}
+
+// recordNode returns the index in f.nodes of the slot that holds the i-th
+// record of Records, or -1 if the record is one that was added to Records
+// directly.
+func (f *File) recordNode(i int) int {
+	k := 0
+	for j, n := range f.nodes {
+		if _, ok := n.(*Record); ok {
+			if k == i {
+				return j
+			}
+			k++
+		}
+	}
+	return -1
+}
+
+// removeNode removes the slot of the i-th record from f.nodes.
+func (f *File) removeNode(i int) {
+	if j := f.recordNode(i); j >= 0 {
+		f.nodes = append(f.nodes[:j], f.nodes[j+1:]...)
+	}
+}
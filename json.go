@@ -0,0 +1,222 @@
+package bibsin
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+)
+
+// The JSON form of a File lists its macro definitions, as fields, and its
+// records. A record keeps its type, key, fields in order (repeated fields
+// included) and source positions; comments, preambles, junk and the layout
+// kept in lossless mode are not part of it.
+//
+//	{
+//	  "name": "refs.bib",
+//	  "macros": [{"name": "acs", "value": "American Chemical Society", ...}],
+//	  "records": [{
+//	    "type": "article",
+//	    "key": "doe2020",
+//	    "line": 3,
+//	    "span": {"start": {"line": 3, "column": 1, "offset": 52}, "end": ...},
+//	    "fields": [
+//	      {"name": "title", "value": "A title", "line": 4, ...},
+//	      {"name": "year", "value": "2020", "delim": "none", ...}
+//	    ]
+//	  }]
+//	}
+
+var delimNames = [...]string{
+	DelimBrace: "brace",
+	DelimQuote: "quote",
+	DelimNone:  "none",
+}
+
+func (d Delim) MarshalText() ([]byte, error) {
+	if int(d) >= len(delimNames) || d < 0 {
+		return nil, fmt.Errorf("invalid delimiter %d", d)
+	}
+	return []byte(delimNames[d]), nil
+}
+
+func (d *Delim) UnmarshalText(text []byte) error {
+	for i, name := range delimNames {
+		if string(text) == name {
+			*d = Delim(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid delimiter %q", text)
+}
+
+// spanPtr returns nil for a zero span so that it is left out of JSON.
+func spanPtr(sp Span) *Span {
+	if sp == (Span{}) {
+		return nil
+	}
+	return &sp
+}
+
+func spanOf(sp *Span) Span {
+	if sp == nil {
+		return Span{}
+	}
+	return *sp
+}
+
+type jsonField struct {
+	Name      string `json:"name"`
+	Value     string `json:"value"`
+	Delim     Delim  `json:"delim,omitempty"`
+	Raw       string `json:"raw,omitempty"`
+	Line      int    `json:"line,omitempty"`
+	NameSpan  *Span  `json:"nameSpan,omitempty"`
+	ValueSpan *Span  `json:"valueSpan,omitempty"`
+}
+
+func (fld Field) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonField{
+		Name:      fld.key,
+		Value:     fld.value,
+		Delim:     fld.delim,
+		Raw:       fld.raw,
+		Line:      fld.line,
+		NameSpan:  spanPtr(fld.nameSpan),
+		ValueSpan: spanPtr(fld.valueSpan),
+	})
+}
+
+func (fld *Field) UnmarshalJSON(data []byte) error {
+	var jf jsonField
+	if err := json.Unmarshal(data, &jf); err != nil {
+		return err
+	}
+	if jf.Name == "" {
+		return fmt.Errorf("field without a name")
+	}
+	*fld = Field{
+		key:       jf.Name,
+		value:     jf.Value,
+		delim:     jf.Delim,
+		raw:       jf.Raw,
+		line:      jf.Line,
+		nameSpan:  spanOf(jf.NameSpan),
+		valueSpan: spanOf(jf.ValueSpan),
+	}
+	return nil
+}
+
+type jsonRecord struct {
+	Type     string  `json:"type"`
+	Key      string  `json:"key"`
+	Parens   bool    `json:"parens,omitempty"`
+	Line     int     `json:"line,omitempty"`
+	Span     *Span   `json:"span,omitempty"`
+	TypeSpan *Span   `json:"typeSpan,omitempty"`
+	KeySpan  *Span   `json:"keySpan,omitempty"`
+	Fields   []Field `json:"fields"`
+}
+
+func (rec *Record) MarshalJSON() ([]byte, error) {
+	fields := rec.fields
+	if fields == nil {
+		fields = []Field{}
+	}
+	return json.Marshal(jsonRecord{
+		Type:     rec.value,
+		Key:      rec.key,
+		Parens:   rec.parens,
+		Line:     rec.line,
+		Span:     spanPtr(rec.span),
+		TypeSpan: spanPtr(rec.typeSpan),
+		KeySpan:  spanPtr(rec.keySpan),
+		Fields:   fields,
+	})
+}
+
+func (rec *Record) UnmarshalJSON(data []byte) error {
+	var jr jsonRecord
+	if err := json.Unmarshal(data, &jr); err != nil {
+		return err
+	}
+	if jr.Type == "" {
+		return fmt.Errorf("record %s without a type", jr.Key)
+	}
+	*rec = Record{
+		fields:   jr.Fields,
+		key:      jr.Key,
+		value:    jr.Type,
+		parens:   jr.Parens,
+		line:     jr.Line,
+		span:     spanOf(jr.Span),
+		typeSpan: spanOf(jr.TypeSpan),
+		keySpan:  spanOf(jr.KeySpan),
+	}
+	return nil
+}
+
+type jsonFile struct {
+	Name    string    `json:"name,omitempty"`
+	Macros  []Field   `json:"macros,omitempty"`
+	Records []*Record `json:"records"`
+}
+
+func (f *File) MarshalJSON() ([]byte, error) {
+	jf := jsonFile{Name: f.name, Records: f.Records}
+	if jf.Records == nil {
+		jf.Records = []*Record{}
+	}
+	for _, n := range f.nodes {
+		if m, ok := n.(*MacroDef); ok {
+			jf.Macros = append(jf.Macros, m.field)
+		}
+	}
+	return json.Marshal(jf)
+}
+
+// UnmarshalJSON replaces f with the File described by data. Macro
+// definitions come before the records in f.Nodes.
+func (f *File) UnmarshalJSON(data []byte) error {
+	var jf jsonFile
+	if err := json.Unmarshal(data, &jf); err != nil {
+		return err
+	}
+	*f = File{name: jf.Name}
+	for _, m := range jf.Macros {
+		f.setMacro(m.key, m.value)
+		f.addNode(&MacroDef{field: m, line: m.line})
+	}
+	for _, rec := range jf.Records {
+		if rec == nil {
+			return fmt.Errorf("null record")
+		}
+		f.AddRecord(rec)
+	}
+	return nil
+}
+
+// ParseJSON reads a File in the JSON form written by File.MarshalJSON,
+// provided as io.Reader or a name of a file. The name recorded in the JSON,
+// if any, is replaced by fileName unless it is empty.
+func ParseJSON(r io.Reader, fileName string) (*File, error) {
+	if r == nil {
+		if fileName == "" {
+			return nil, fmt.Errorf("nothing to parse")
+		}
+		f, err := os.Open(fileName)
+		if err != nil {
+			return nil, fmt.Errorf("can't process file %s: %w", fileName, err)
+		}
+		defer f.Close()
+		r = f
+	}
+	var f File
+	if err := json.NewDecoder(r).Decode(&f); err != nil {
+		return nil, fmt.Errorf("can't parse %s: %w", fileName, err)
+	}
+	if fileName != "" {
+		f.name = fileName
+	}
+	return &f, nil
+}
@@ -0,0 +1,589 @@
+package bibsin
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// BibTeX files write characters outside ASCII, and a few inside it, as
+// LaTeX: Jo{\~a}o, Stra\ss e, Johnson \& Johnson, 1--10, $\alpha$.
+// DecodeLaTeX turns such values into Unicode text and EncodeLaTeX turns
+// Unicode text back into LaTeX that BibTeX and biber both understand.
+// Verbatim fields, such as url and doi, are never changed by the options
+// and methods that apply them to records.
+
+// verbatimFields lists the fields whose values are not LaTeX.
+var verbatimFields = map[string]bool{
+	"url": true, "urlraw": true, "doi": true, "eprint": true, "file": true, "pdf": true,
+}
+
+func isVerbatim(name string) bool {
+	return verbatimFields[strings.ToLower(name)]
+}
+
+// accents lists, for each LaTeX accent command, pairs of a letter and the
+// same letter with the accent.
+var accents = map[string]string{
+	"`": "AÀaàEÈeèIÌiìNǸnǹOÒoòUÙuùWẀwẁYỲyỳ",
+	"'": "AÁaáCĆcćEÉeéGǴgǵIÍiíKḰkḱLĹlĺNŃnńOÓoóRŔrŕSŚsśUÚuúWẂwẃYÝyýZŹzź",
+	"^": "AÂaâCĈcĉEÊeêGĜgĝHĤhĥIÎiîJĴjĵOÔoôSŜsŝUÛuûWŴwŵYŶyŷZẐzẑ",
+	"~": "AÃaãEẼeẽIĨiĩNÑnñOÕoõUŨuũYỸyỹ",
+	"=": "AĀaāEĒeēGḠgḡIĪiīOŌoōUŪuūYȲyȳ",
+	"u": "AĂaăEĔeĕGĞgğIĬiĭOŎoŏUŬuŭ",
+	".": "AȦaȧCĊcċDḊdḋEĖeėGĠgġHḢhḣIİNṄnṅOȮoȯRṘrṙSṠsṡTṪtṫWẆwẇYẎyẏZŻzż",
+	`"`: "AÄaäEËeëHḦhḧIÏiïOÖoötẗUÜuüWẄwẅYŸyÿ",
+	"r": "AÅaåUŮuůwẘyẙ",
+	"H": "OŐoőUŰuű",
+	"v": "AǍaǎCČcčDĎdďEĚeěGǦgǧHȞhȟIǏiǐjǰKǨkǩLĽlľNŇnňOǑoǒRŘrřSŠsšTŤtťUǓuǔZŽzž",
+	"d": "AẠaạDḌdḍEẸeẹHḤhḥIỊiịKḲkḳLḶlḷNṆnṇOỌoọRṚrṛSṢsṣTṬtṭUỤuụWẈwẉYỴyỵZẒzẓ",
+	"c": "CÇcçDḐdḑEȨeȩGĢgģHḨhḩKĶkķLĻlļNŅnņRŖrŗSŞsşTŢtţ",
+	"k": "AĄaąEĘeęIĮiįOǪoǫUŲuų",
+	"b": "DḎdḏhẖKḴkḵLḺlḻNṈnṉRṞrṟTṮtṯZẔzẕ",
+}
+
+// combining holds the combining mark of each accent, used for letters that
+// have no precomposed form with it.
+var combining = map[string]rune{
+	"`": '̀', "'": '́', "^": '̂', "~": '̃', "=": '̄',
+	"u": '̆', ".": '̇', `"`: '̈', "r": '̊', "H": '̋',
+	"v": '̌', "d": '̣', "c": '̧', "k": '̨', "b": '̱',
+	"t": '͡',
+}
+
+type accented struct {
+	accent string
+	base   rune
+}
+
+var accentChars, accentOf = accentTables()
+
+func accentTables() (map[accented]rune, map[rune]accented) {
+	chars := make(map[accented]rune)
+	of := make(map[rune]accented)
+	for accent, pairs := range accents {
+		runes := []rune(pairs)
+		for i := 0; i+1 < len(runes); i += 2 {
+			chars[accented{accent, runes[i]}] = runes[i+1]
+			of[runes[i+1]] = accented{accent, runes[i]}
+		}
+	}
+	return chars, of
+}
+
+// latexSymbol is a command that stands for a character.
+type latexSymbol struct {
+	cmd, text string
+	math      bool // a math mode command
+}
+
+// latexSymbols lists the commands that DecodeLaTeX knows. When several
+// commands stand for the same character, EncodeLaTeX uses the first one.
+var latexSymbols = []latexSymbol{
+	{"o", "ø", false}, {"O", "Ø", false}, {"ss", "ß", false},
+	{"ae", "æ", false}, {"AE", "Æ", false}, {"oe", "œ", false}, {"OE", "Œ", false},
+	{"aa", "å", false}, {"AA", "Å", false}, {"l", "ł", false}, {"L", "Ł", false},
+	{"i", "ı", false}, {"j", "ȷ", false}, {"dh", "ð", false}, {"DH", "Ð", false},
+	{"th", "þ", false}, {"TH", "Þ", false}, {"dj", "đ", false}, {"DJ", "Đ", false},
+	{"ng", "ŋ", false}, {"NG", "Ŋ", false},
+	{"S", "§", false}, {"P", "¶", false}, {"dag", "†", false}, {"ddag", "‡", false},
+	{"copyright", "©", false}, {"pounds", "£", false}, {"euro", "€", false},
+	{"textendash", "–", false}, {"textemdash", "—", false},
+	{"textbar", "|", false}, {"textless", "<", false}, {"textgreater", ">", false},
+	{"textbackslash", `\`, false}, {"textasciitilde", "~", false}, {"textasciicircum", "^", false},
+	{"texttrademark", "™", false}, {"textregistered", "®", false},
+	{"textexclamdown", "¡", false}, {"textquestiondown", "¿", false},
+	{"textdegree", "°", false}, {"textellipsis", "…", false}, {"ldots", "…", false}, {"dots", "…", false},
+	{"textquoteleft", "‘", false}, {"textquoteright", "’", false},
+	{"textquotedblleft", "“", false}, {"textquotedblright", "”", false},
+	{"guillemotleft", "«", false}, {"guillemotright", "»", false},
+	{"textperiodcentered", "·", false}, {"textbullet", "•", false},
+	{",", "\u2009", false}, // thin space
+
+	{"alpha", "α", true}, {"beta", "β", true}, {"gamma", "γ", true}, {"delta", "δ", true},
+	{"epsilon", "ϵ", true}, {"varepsilon", "ε", true}, {"zeta", "ζ", true}, {"eta", "η", true},
+	{"theta", "θ", true}, {"vartheta", "ϑ", true}, {"iota", "ι", true}, {"kappa", "κ", true},
+	{"lambda", "λ", true}, {"mu", "μ", true}, {"nu", "ν", true}, {"xi", "ξ", true},
+	{"pi", "π", true}, {"rho", "ρ", true}, {"sigma", "σ", true}, {"tau", "τ", true},
+	{"upsilon", "υ", true}, {"phi", "ϕ", true}, {"varphi", "φ", true}, {"chi", "χ", true},
+	{"psi", "ψ", true}, {"omega", "ω", true},
+	{"Gamma", "Γ", true}, {"Delta", "Δ", true}, {"Theta", "Θ", true}, {"Lambda", "Λ", true},
+	{"Xi", "Ξ", true}, {"Pi", "Π", true}, {"Sigma", "Σ", true}, {"Upsilon", "Υ", true},
+	{"Phi", "Φ", true}, {"Psi", "Ψ", true}, {"Omega", "Ω", true},
+	{"pm", "±", true}, {"mp", "∓", true}, {"times", "×", true}, {"div", "÷", true},
+	{"cdot", "⋅", true}, {"ast", "∗", true}, {"star", "⋆", true}, {"circ", "∘", true},
+	{"bullet", "∙", true}, {"leq", "≤", true}, {"le", "≤", true}, {"geq", "≥", true},
+	{"ge", "≥", true}, {"neq", "≠", true}, {"ne", "≠", true}, {"ll", "≪", true},
+	{"gg", "≫", true}, {"approx", "≈", true}, {"sim", "∼", true}, {"simeq", "≃", true},
+	{"equiv", "≡", true}, {"propto", "∝", true}, {"infty", "∞", true}, {"partial", "∂", true},
+	{"nabla", "∇", true}, {"sum", "∑", true}, {"prod", "∏", true}, {"int", "∫", true},
+	{"sqrt", "√", true}, {"rightarrow", "→", true}, {"to", "→", true}, {"leftarrow", "←", true},
+	{"leftrightarrow", "↔", true}, {"Rightarrow", "⇒", true}, {"Leftarrow", "⇐", true},
+	{"Leftrightarrow", "⇔", true}, {"uparrow", "↑", true}, {"downarrow", "↓", true},
+	{"prime", "′", true}, {"in", "∈", true}, {"notin", "∉", true}, {"subset", "⊂", true},
+	{"supset", "⊃", true}, {"cap", "∩", true}, {"cup", "∪", true}, {"emptyset", "∅", true},
+	{"forall", "∀", true}, {"exists", "∃", true}, {"neg", "¬", true}, {"wedge", "∧", true},
+	{"vee", "∨", true}, {"perp", "⊥", true}, {"parallel", "∥", true}, {"angle", "∠", true},
+	{"ell", "ℓ", true}, {"hbar", "ℏ", true}, {"langle", "⟨", true}, {"rangle", "⟩", true},
+	{"backslash", `\`, true}, {"dagger", "†", true},
+}
+
+var symbolText, symbolOf = symbolTables()
+
+func symbolTables() (map[string]latexSymbol, map[rune]latexSymbol) {
+	text := make(map[string]latexSymbol, len(latexSymbols))
+	of := make(map[rune]latexSymbol, len(latexSymbols))
+	for _, sym := range latexSymbols {
+		text[sym.cmd] = sym
+		r, _ := utf8.DecodeRuneInString(sym.text)
+		if _, ok := of[r]; !ok && r >= utf8.RuneSelf {
+			of[r] = sym
+		}
+	}
+	return text, of
+}
+
+// latexStyles lists the commands whose argument is kept as text.
+var latexStyles = map[string]bool{
+	"emph": true, "textit": true, "textbf": true, "textsc": true, "textrm": true,
+	"textsf": true, "texttt": true, "textup": true, "textsl": true, "textnormal": true,
+	"mbox": true, "text": true, "mathrm": true, "mathit": true, "mathbf": true,
+	"mathsf": true, "mathtt": true, "ensuremath": true, "NoCaseChange": true,
+}
+
+// latexDeclarations lists the commands that change the style of the text
+// that follows them and are dropped.
+var latexDeclarations = map[string]bool{
+	"em": true, "it": true, "bf": true, "sc": true, "rm": true, "sf": true,
+	"tt": true, "sl": true, "itshape": true, "bfseries": true, "scshape": true,
+	"upshape": true, "normalfont": true, "relax": true,
+}
+
+// latexEscapes are the characters written with a backslash in front.
+const latexEscapes = "&%$#_"
+
+var (
+	superscripts = runeMap("0123456789+-=()ni", "⁰¹²³⁴⁵⁶⁷⁸⁹⁺⁻⁼⁽⁾ⁿⁱ")
+	subscripts   = runeMap("0123456789+-=()", "₀₁₂₃₄₅₆₇₈₉₊₋₌₍₎")
+	unsuper      = runeMap("⁰¹²³⁴⁵⁶⁷⁸⁹⁺⁻⁼⁽⁾ⁿⁱ", "0123456789+-=()ni")
+	unsub        = runeMap("₀₁₂₃₄₅₆₇₈₉₊₋₌₍₎", "0123456789+-=()")
+)
+
+func runeMap(from, to string) map[rune]rune {
+	m := make(map[rune]rune)
+	t := []rune(to)
+	for i, r := range []rune(from) {
+		m[r] = t[i]
+	}
+	return m
+}
+
+// DecodeLaTeX returns s with its LaTeX replaced by Unicode text: accents
+// with or without braces (\'e, \'{e}, {\'e}, \c{c}), special letters (\o,
+// \ss, \ae), escaped characters (\&, \%), dashes (-- and ---), quotes and
+// ties, and math mode ($\alpha$, $\geq$, H$_2$O, 10$^{-3}$). The arguments of
+// style commands such as \emph are kept as text. Braces that protect text
+// from case changes are kept; those around a special character are not.
+// Unknown commands are left as they are.
+func DecodeLaTeX(s string) string {
+	return decodeLaTeX(s, true)
+}
+
+// decodeLaTeX is DecodeLaTeX that also drops protecting braces unless
+// keepBraces is true.
+func decodeLaTeX(s string, keepBraces bool) string {
+	if !strings.ContainsAny(s, "\\{}$-~`'") {
+		return s
+	}
+	d := latexDecoder{keepBraces: keepBraces}
+	d.decode(s, false)
+	return d.sb.String()
+}
+
+type latexDecoder struct {
+	sb         strings.Builder
+	keepBraces bool
+	keepStyles bool // keep style commands and escaped braces as LaTeX
+}
+
+// decode decodes s in text or math mode.
+func (d *latexDecoder) decode(s string, math bool) {
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == '\\':
+			i = d.command(s, i+1, math)
+		case c == LBRACE:
+			end := groupEnd(s, i)
+			if end < 0 {
+				d.sb.WriteByte(c)
+				i++
+				continue
+			}
+			inner := s[i+1 : end]
+			keep := d.keepBraces && !math && (!strings.HasPrefix(inner, `\`) ||
+				d.keepStyles && latexDeclarations[commandName(inner)])
+			if keep {
+				d.sb.WriteByte(LBRACE)
+			}
+			d.decode(inner, math)
+			if keep {
+				d.sb.WriteByte(RBRACE)
+			}
+			i = end + 1
+		case c == '$' && !math:
+			end := mathEnd(s, i+1)
+			if end < 0 {
+				d.sb.WriteByte(c)
+				i++
+				continue
+			}
+			d.decode(s[i+1:end], true)
+			i = end + 1
+		case math && (c == '^' || c == '_'):
+			arg, next := argument(s, i+1)
+			d.script(arg, c == '^', math)
+			i = next
+		case !math && c == '-':
+			n := 1
+			for n < 3 && i+n < len(s) && s[i+n] == '-' {
+				n++
+			}
+			d.sb.WriteString([...]string{"-", "–", "—"}[n-1])
+			i += n
+		case !math && c == '~':
+			d.sb.WriteRune('\u00a0') // no-break space
+			i++
+		case !math && strings.HasPrefix(s[i:], "``"):
+			d.sb.WriteString("“")
+			i += 2
+		case !math && strings.HasPrefix(s[i:], "''"):
+			d.sb.WriteString("”")
+			i += 2
+		default:
+			d.sb.WriteByte(c)
+			i++
+		}
+	}
+}
+
+// command decodes the command whose name starts at s[i], after the
+// backslash, and returns where the text after it starts.
+func (d *latexDecoder) command(s string, i int, math bool) int {
+	if i >= len(s) {
+		d.sb.WriteByte('\\')
+		return i
+	}
+	j := i
+	for j < len(s) && isASCIILetter(s[j]) {
+		j++
+	}
+	k := j // after the spaces that end a command word
+	if j == i {
+		_, size := utf8.DecodeRuneInString(s[i:])
+		j += size
+		k = j
+	} else {
+		for k < len(s) && s[k] == ' ' {
+			k++
+		}
+	}
+	name := s[i:j]
+	if _, ok := combining[name]; ok {
+		arg, next := argument(s, j)
+		d.accent(name, arg, math)
+		return next
+	}
+	if sym, ok := symbolText[name]; ok {
+		d.sb.WriteString(sym.text)
+		if strings.HasPrefix(s[k:], "{}") {
+			k += 2
+		}
+		return k
+	}
+	switch {
+	case d.keepStyles && (name == "{" || name == "}"):
+		d.sb.WriteString(s[i-1 : j])
+	case strings.Contains(latexEscapes, name) || name == "{" || name == "}":
+		d.sb.WriteString(name)
+	case name == " " || name == `\`:
+		d.sb.WriteByte(' ')
+	case name == "-" || name == "/":
+		// hyphenation point and italic correction
+	case latexStyles[name]:
+		arg, next := argument(s, j)
+		if d.keepStyles {
+			d.sb.WriteString(s[i-1:j] + "{")
+			d.decode(arg, math)
+			d.sb.WriteByte(RBRACE)
+			return next
+		}
+		d.decode(arg, math)
+		return next
+	case name == "textsuperscript" || name == "textsubscript":
+		arg, next := argument(s, j)
+		d.script(arg, name == "textsuperscript", math)
+		return next
+	case latexDeclarations[name]:
+		if d.keepStyles {
+			d.sb.WriteString(s[i-1 : k])
+		}
+		return k
+	default:
+		d.sb.WriteString(s[i-1 : k])
+		return k
+	}
+	return j
+}
+
+// accent writes the decoded arg with the accent.
+func (d *latexDecoder) accent(name, arg string, math bool) {
+	sub := latexDecoder{}
+	sub.decode(arg, math)
+	text := sub.sb.String()
+	r, size := utf8.DecodeRuneInString(text)
+	if size == 0 {
+		return
+	}
+	base := r
+	switch r {
+	case 'ı':
+		base = 'i'
+	case 'ȷ':
+		base = 'j'
+	}
+	if c, ok := accentChars[accented{name, base}]; ok && size == len(text) {
+		d.sb.WriteRune(c)
+		return
+	}
+	d.sb.WriteRune(r)
+	d.sb.WriteRune(combining[name])
+	d.sb.WriteString(text[size:])
+}
+
+// script writes the decoded arg of ^ or _ as superscript or subscript
+// characters, or as plain text if some have none.
+func (d *latexDecoder) script(arg string, sup, math bool) {
+	sub := latexDecoder{}
+	sub.decode(arg, math)
+	text := sub.sb.String()
+	if sup && text == "∘" {
+		d.sb.WriteString("°")
+		return
+	}
+	table := subscripts
+	if sup {
+		table = superscripts
+	}
+	var sb strings.Builder
+	for _, r := range text {
+		c, ok := table[r]
+		if !ok {
+			d.sb.WriteString(text)
+			return
+		}
+		sb.WriteRune(c)
+	}
+	d.sb.WriteString(sb.String())
+}
+
+// argument returns the argument of a command that starts at s[i], after
+// spaces: a braced group, without its braces, a command or a character. It
+// also returns where the text after it starts.
+func argument(s string, i int) (string, int) {
+	for i < len(s) && s[i] == ' ' {
+		i++
+	}
+	if i >= len(s) {
+		return "", i
+	}
+	switch s[i] {
+	case LBRACE:
+		end := groupEnd(s, i)
+		if end < 0 {
+			return s[i+1:], len(s)
+		}
+		return s[i+1 : end], end + 1
+	case '\\':
+		j := i + 1
+		for j < len(s) && isASCIILetter(s[j]) {
+			j++
+		}
+		if j == i+1 && j < len(s) {
+			j++
+		}
+		return s[i:j], j
+	}
+	_, size := utf8.DecodeRuneInString(s[i:])
+	return s[i : i+size], i + size
+}
+
+// groupEnd returns the index of the brace that closes the one at s[i], or
+// -1 if there is none.
+func groupEnd(s string, i int) int {
+	depth := 0
+	for ; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case LBRACE:
+			depth++
+		case RBRACE:
+			if depth--; depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// mathEnd returns the index of the $ that ends the math started before
+// s[i], or -1 if there is none.
+func mathEnd(s string, i int) int {
+	for ; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '$':
+			return i
+		}
+	}
+	return -1
+}
+
+// commandName returns the name of the command that starts s, after the
+// backslash.
+func commandName(s string) string {
+	i := 1
+	for i < len(s) && isASCIILetter(s[i]) {
+		i++
+	}
+	return s[1:i]
+}
+
+func isASCIILetter(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// EncodeLaTeX returns the Unicode text s written as LaTeX, the reverse of
+// DecodeLaTeX: accented and special letters become commands in braces
+// ({\'e}, {\ss}), &, %, $, # and _ are escaped unless they already are,
+// dashes, curly quotes and no-break spaces become their TeX ligatures and
+// mathematical symbols are written in math mode. Braces and backslashes are
+// left alone, so s must not hold LaTeX other than escaped characters.
+// Characters that LaTeX has no command for are kept as they are.
+func EncodeLaTeX(s string) string {
+	var buf []byte
+	mathEnd := -1 // where the last math written ends
+	math := func(tex string) {
+		if len(buf) == mathEnd {
+			buf = buf[:len(buf)-1] // join with the math before
+		} else {
+			buf = append(buf, '$')
+		}
+		buf = append(buf, tex...)
+		buf = append(buf, '$')
+		mathEnd = len(buf)
+	}
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		switch {
+		case r == '~':
+			buf = append(buf, `{\textasciitilde}`...)
+		case r < utf8.RuneSelf && strings.ContainsRune(latexEscapes, r):
+			if i == 0 || s[i-1] != '\\' {
+				buf = append(buf, '\\')
+			}
+			buf = append(buf, byte(r))
+		case r < utf8.RuneSelf:
+			buf = append(buf, byte(r))
+		case r == '\u00a0':
+			buf = append(buf, '~')
+		case r == '–':
+			buf = append(buf, "--"...)
+		case r == '—':
+			buf = append(buf, "---"...)
+		case r == '“':
+			buf = append(buf, "``"...)
+		case r == '”':
+			buf = append(buf, "''"...)
+		case symbolOf[r].cmd != "":
+			sym := symbolOf[r]
+			switch {
+			case sym.math:
+				math(`\` + sym.cmd)
+			case isASCIILetter(sym.cmd[0]):
+				buf = append(buf, `{\`+sym.cmd+`}`...)
+			default:
+				buf = append(buf, `\`+sym.cmd...)
+			}
+		case accentOf[r].accent != "":
+			a := accentOf[r]
+			if isASCIILetter(a.accent[0]) {
+				buf = append(buf, `{\`+a.accent+`{`+string(a.base)+`}}`...)
+			} else {
+				buf = append(buf, `{\`+a.accent+string(a.base)+`}`...)
+			}
+		case unsuper[r] != 0 || unsub[r] != 0:
+			// a run of superscripts or subscripts
+			table, op := unsuper, "^{"
+			if unsub[r] != 0 {
+				table, op = unsub, "_{"
+			}
+			tex := []byte(op)
+			for {
+				c, ok := table[r]
+				if !ok {
+					break
+				}
+				tex = utf8.AppendRune(tex, c)
+				i += size
+				r, size = utf8.DecodeRuneInString(s[i:])
+			}
+			math(string(tex) + "}")
+			continue
+		default:
+			buf = append(buf, s[i:i+size]...)
+		}
+		i += size
+	}
+	return string(buf)
+}
+
+// DecodeLaTeX replaces the LaTeX in the values of the fields of rec by
+// Unicode text as DecodeLaTeX does. Verbatim fields, such as url and doi,
+// and fields kept with their macros are left alone.
+func (rec *Record) DecodeLaTeX() {
+	transformFields(rec.fields, DecodeLaTeX)
+}
+
+// EncodeLaTeX writes the values of the fields of rec as LaTeX as
+// EncodeLaTeX does. Values may already hold LaTeX, as when the file was
+// parsed without Options.DecodeLaTeX: they are decoded first, keeping
+// style commands such as \emph, so that Jo{\~a}o and A~B stay as they are.
+// Verbatim fields, such as url and doi, and fields kept with their macros
+// are left alone.
+func (rec *Record) EncodeLaTeX() {
+	transformFields(rec.fields, encodeValue)
+}
+
+// encodeValue returns the field value s, which may mix LaTeX and Unicode
+// text, written as LaTeX.
+func encodeValue(s string) string {
+	d := latexDecoder{keepBraces: true, keepStyles: true}
+	d.decode(s, false)
+	return EncodeLaTeX(d.sb.String())
+}
+
+// transformFields applies fn to the values of fields, except verbatim ones
+// and those kept with their macros.
+func transformFields(fields []Field, fn func(string) string) {
+	for i := range fields {
+		fld := &fields[i]
+		if isVerbatim(fld.key) || fld.raw != "" {
+			continue
+		}
+		if v := fn(fld.value); v != fld.value {
+			fld.setValue(v)
+		}
+	}
+}
@@ -0,0 +1,350 @@
+package bibsin
+
+import (
+	"bufio"
+	"io"
+	"strings"
+)
+
+type tokenKind int8
+
+const (
+	tokEOF tokenKind = iota
+	tokIllegal
+	tokAt
+	tokLBrace
+	tokRBrace
+	tokLParen
+	tokRParen
+	tokComma
+	tokEqual
+	tokHash
+	tokQuote
+	tokName
+)
+
+var tokenNames = [...]string{
+	tokEOF:     "end of file",
+	tokIllegal: "illegal character",
+	tokAt:      "@",
+	tokLBrace:  "{",
+	tokRBrace:  "}",
+	tokLParen:  "(",
+	tokRParen:  ")",
+	tokComma:   ",",
+	tokEqual:   "=",
+	tokHash:    "#",
+	tokQuote:   `"`,
+	tokName:    "name",
+}
+
+func (k tokenKind) String() string {
+	return tokenNames[k]
+}
+
+type token struct {
+	kind        tokenKind
+	text        string
+	pos, endPos Pos // span of the token
+	start, end  int // offsets of the token in lexer.raw
+}
+
+// lexer splits a bibtex stream into tokens. Unlike the structure of a
+// record, the layout of the input (line breaks, indentation) carries no
+// meaning. Because the grammar is context sensitive, the parser tells the
+// lexer what to expect next: junk between entries, a citation key or the
+// contents of a delimited value.
+type lexer struct {
+	r    *bufio.Reader
+	back []byte // bytes pushed back by unread; consumed before r
+	pos  Pos    // position of the next byte
+	raw  []byte // bytes consumed since the last resetRaw
+	max  int    // if positive, the length raw may not exceed
+	// overflow is set when input was refused because of max
+	overflow bool
+	err      error // read error other than io.EOF
+}
+
+func newLexer(r io.Reader) *lexer {
+	return &lexer{
+		r:   bufio.NewReaderSize(r, 2048),
+		pos: Pos{Line: 1, Column: 1},
+	}
+}
+
+func (lx *lexer) readByte() (byte, bool) {
+	if lx.full() {
+		return 0, false
+	}
+	var c byte
+	if len(lx.back) > 0 {
+		c = lx.back[0]
+		lx.back = lx.back[1:]
+	} else {
+		b, err := lx.r.ReadByte()
+		if err != nil {
+			lx.setErr(err)
+			return 0, false
+		}
+		c = b
+	}
+	lx.raw = append(lx.raw, c)
+	lx.pos.Offset++
+	switch {
+	case c == '\n':
+		lx.pos.Line++
+		lx.pos.Column = 1
+	case c&0xC0 != 0x80: // not a UTF-8 continuation byte
+		lx.pos.Column++
+	}
+	return c, true
+}
+
+func (lx *lexer) peekByte() (byte, bool) {
+	if lx.full() {
+		return 0, false
+	}
+	if len(lx.back) > 0 {
+		return lx.back[0], true
+	}
+	b, err := lx.r.Peek(1)
+	if err != nil {
+		lx.setErr(err)
+		return 0, false
+	}
+	return b[0], true
+}
+
+// unread pushes back the last n consumed bytes so that they are read again;
+// pos is the position of the first of them.
+func (lx *lexer) unread(n int, pos Pos) {
+	b := lx.raw[len(lx.raw)-n:]
+	lx.back = append(append([]byte(nil), b...), lx.back...)
+	lx.raw = lx.raw[:len(lx.raw)-n]
+	lx.pos = pos
+}
+
+func (lx *lexer) setErr(err error) {
+	if err != io.EOF {
+		lx.err = err
+	}
+}
+
+func (lx *lexer) resetRaw() {
+	lx.raw = lx.raw[:0]
+}
+
+// setLimit makes the lexer behave as if the input ended after n more
+// bytes. A zero n removes the limit.
+func (lx *lexer) setLimit(n int) {
+	lx.max, lx.overflow = 0, false
+	if n > 0 {
+		lx.max = len(lx.raw) + n
+	}
+}
+
+func (lx *lexer) full() bool {
+	if lx.max > 0 && len(lx.raw) >= lx.max {
+		lx.overflow = true
+	}
+	return lx.overflow
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' || c == '\v'
+}
+
+// isNameByte reports whether c can be part of a bibtex identifier
+// (entry type, field name or macro name).
+func isNameByte(c byte) bool {
+	return !isSpace(c) && strings.IndexByte("\"#%'(),={}@", c) == -1
+}
+
+func (lx *lexer) skipSpaces() {
+	for {
+		c, ok := lx.peekByte()
+		if !ok || !isSpace(c) {
+			return
+		}
+		lx.readByte()
+	}
+}
+
+// next returns the next token skipping any leading white space.
+func (lx *lexer) next() (tok token) {
+	lx.skipSpaces()
+	tok = token{pos: lx.pos, start: len(lx.raw)}
+	defer func() { tok.endPos, tok.end = lx.pos, len(lx.raw) }()
+	c, ok := lx.readByte()
+	if !ok {
+		tok.kind = tokEOF
+		return tok
+	}
+	switch c {
+	case AT:
+		tok.kind = tokAt
+	case LBRACE:
+		tok.kind = tokLBrace
+	case RBRACE:
+		tok.kind = tokRBrace
+	case LPAREN:
+		tok.kind = tokLParen
+	case RPAREN:
+		tok.kind = tokRParen
+	case COMMA:
+		tok.kind = tokComma
+	case EQUAL:
+		tok.kind = tokEqual
+	case HASH:
+		tok.kind = tokHash
+	case QUOTE:
+		tok.kind = tokQuote
+	default:
+		if !isNameByte(c) {
+			tok.kind = tokIllegal
+			tok.text = string(c)
+			return tok
+		}
+		b := []byte{c}
+		for {
+			c, ok := lx.peekByte()
+			if !ok || !isNameByte(c) {
+				break
+			}
+			lx.readByte()
+			b = append(b, c)
+		}
+		tok.kind = tokName
+		tok.text = string(b)
+	}
+	return tok
+}
+
+// junk consumes everything up to, but excluding, the next @ and returns it.
+// It returns false if the input was exhausted.
+func (lx *lexer) junk() (string, bool) {
+	var sb strings.Builder
+	for {
+		c, ok := lx.peekByte()
+		if !ok {
+			return sb.String(), false
+		}
+		if c == AT {
+			return sb.String(), true
+		}
+		lx.readByte()
+		sb.WriteByte(c)
+	}
+}
+
+// key reads a citation key, which runs up to the first comma, white space
+// or closing delimiter.
+func (lx *lexer) key(closing byte) string {
+	lx.skipSpaces()
+	var b []byte
+	for {
+		c, ok := lx.peekByte()
+		if !ok || c == COMMA || c == closing || isSpace(c) {
+			return string(b)
+		}
+		lx.readByte()
+		b = append(b, c)
+	}
+}
+
+// braced reads the contents of a brace-delimited string whose opening
+// brace was already consumed, up to the matching closing brace. It returns
+// false if the input ends before the braces balance.
+func (lx *lexer) braced() (string, bool) {
+	return lx.balanced(LBRACE, RBRACE)
+}
+
+// balanced reads up to the closing delimiter that matches an already
+// consumed opening one.
+func (lx *lexer) balanced(opening, closing byte) (string, bool) {
+	var sb strings.Builder
+	depth := 0
+	for {
+		c, ok := lx.readByte()
+		if !ok {
+			return sb.String(), false
+		}
+		switch c {
+		case opening:
+			depth++
+		case closing:
+			if depth == 0 {
+				return sb.String(), true
+			}
+			depth--
+		case '\r':
+			if n, _ := lx.peekByte(); n == '\n' {
+				continue
+			}
+		}
+		sb.WriteByte(c)
+	}
+}
+
+// quoted reads the contents of a quote-delimited string whose opening quote
+// was already consumed. Quotes nested in braces do not end the string.
+//
+// A quoted string that is never closed swallows the rest of its record,
+// which usually shows up as a closing brace that has no opening partner.
+// In that case, quoted gives back everything after the first line break and
+// returns the text of the first line with ok set to false; the caller may
+// then treat that line as the value, the way a line-oriented reader would.
+func (lx *lexer) quoted() (s string, ok bool) {
+	var sb strings.Builder
+	depth := 0
+	nl, nlRaw, nlPos := -1, 0, Pos{}
+	for {
+		pos := lx.pos
+		c, more := lx.readByte()
+		if !more || (c == RBRACE && depth == 0) {
+			if nl >= 0 {
+				lx.unread(len(lx.raw)-nlRaw, nlPos)
+				return sb.String()[:nl], false
+			}
+			if more {
+				lx.unread(1, pos)
+			}
+			return sb.String(), false
+		}
+		switch c {
+		case QUOTE:
+			if depth == 0 {
+				return sb.String(), true
+			}
+		case LBRACE:
+			depth++
+		case RBRACE:
+			depth--
+		case '\r':
+			if n, _ := lx.peekByte(); n == '\n' {
+				continue
+			}
+		case '\n':
+			if nl < 0 {
+				nl, nlRaw, nlPos = sb.Len(), len(lx.raw)-1, pos
+			}
+		}
+		sb.WriteByte(c)
+	}
+}
+
+// restOfLine consumes and returns the remainder of the current line
+// including the line break.
+func (lx *lexer) restOfLine() string {
+	var sb strings.Builder
+	for {
+		c, ok := lx.readByte()
+		if !ok {
+			return sb.String()
+		}
+		sb.WriteByte(c)
+		if c == '\n' {
+			return sb.String()
+		}
+	}
+}
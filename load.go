@@ -0,0 +1,155 @@
+package bibsin
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// LoadErrors lists the problems found by ParseFiles or ParseDir: patterns
+// that match nothing, files that could not be read and, in file order, the
+// ParseErrors of the others.
+type LoadErrors []error
+
+func (el LoadErrors) Error() string {
+	switch len(el) {
+	case 0:
+		return "no errors"
+	case 1:
+		return el[0].Error()
+	}
+	return fmt.Sprintf("%s (and %d more errors)", el[0], len(el)-1)
+}
+
+// Unwrap lets errors.Is and errors.As look into the errors of each file.
+func (el LoadErrors) Unwrap() []error {
+	return el
+}
+
+// ParseFiles parses the files matching the glob patterns, as understood by
+// filepath.Match, in the order of the patterns; the matches of a pattern
+// are in lexical order and a file matched twice is parsed once. A pattern
+// naming a directory stands for the .bib files in it.
+//
+// Files are parsed concurrently by at most opts.Workers goroutines. Files
+// that could not be read are left out of the result; those with problems
+// are returned as far as they could be parsed. All problems are returned
+// as LoadErrors.
+func ParseFiles(patterns []string, opts Options) ([]*File, error) {
+	var paths []string
+	var errs LoadErrors
+	for _, pat := range patterns {
+		matches, err := filepath.Glob(pat)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid pattern %s: %w", pat, err))
+			continue
+		}
+		if len(matches) == 0 {
+			errs = append(errs, fmt.Errorf("no files match %s", pat))
+			continue
+		}
+		for _, path := range matches {
+			if fi, err := os.Stat(path); err == nil && fi.IsDir() {
+				sub, err := bibFiles(path, false)
+				if err != nil {
+					errs = append(errs, err)
+				}
+				paths = append(paths, sub...)
+				continue
+			}
+			paths = append(paths, path)
+		}
+	}
+	return parseAll(paths, errs, opts)
+}
+
+// ParseDir parses the .bib files in dir, and those in its subdirectories if
+// recursive is true, in lexical order. Like ParseFiles, it parses the files
+// concurrently and returns their problems as LoadErrors.
+func ParseDir(dir string, recursive bool, opts Options) ([]*File, error) {
+	paths, err := bibFiles(dir, recursive)
+	if err != nil {
+		return nil, LoadErrors{err}
+	}
+	return parseAll(paths, nil, opts)
+}
+
+// bibFiles lists the .bib files in dir.
+func bibFiles(dir string, recursive bool) ([]string, error) {
+	var paths []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path != dir && !recursive {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if strings.EqualFold(filepath.Ext(path), ".bib") {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return paths, fmt.Errorf("can't list %s: %w", dir, err)
+	}
+	return paths, nil
+}
+
+// parseAll parses paths with a pool of workers and returns the files in the
+// order of paths. The problems found are appended to errs.
+func parseAll(paths []string, errs LoadErrors, opts Options) ([]*File, error) {
+	seen := make(map[string]bool, len(paths))
+	unique := paths[:0:0]
+	for _, path := range paths {
+		if p := filepath.Clean(path); !seen[p] {
+			seen[p] = true
+			unique = append(unique, path)
+		}
+	}
+	paths = unique
+	files := make([]*File, len(paths))
+	fileErrs := make([]error, len(paths))
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	if workers > len(paths) {
+		workers = len(paths)
+	}
+	work := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				files[i], fileErrs[i] = Parse(nil, paths[i], opts)
+			}
+		}()
+	}
+	for i := range paths {
+		work <- i
+	}
+	close(work)
+	wg.Wait()
+	res := make([]*File, 0, len(files))
+	for i, f := range files {
+		if fileErrs[i] != nil {
+			errs = append(errs, fileErrs[i])
+		}
+		if f != nil {
+			res = append(res, f)
+		}
+	}
+	if len(errs) > 0 {
+		return res, errs
+	}
+	return res, nil
+}
@@ -0,0 +1,102 @@
+package bibsin
+
+import "strings"
+
+// monthMacros are the month abbreviations predefined by the standard
+// bibtex styles.
+var monthMacros = map[string]string{
+	"jan": "January",
+	"feb": "February",
+	"mar": "March",
+	"apr": "April",
+	"may": "May",
+	"jun": "June",
+	"jul": "July",
+	"aug": "August",
+	"sep": "September",
+	"oct": "October",
+	"nov": "November",
+	"dec": "December",
+}
+
+// Macro returns the value of the @string macro name defined in f or, failing
+// that, of the predefined month macro. Macro names are case insensitive.
+func (f *File) Macro(name string) (string, bool) {
+	name = strings.ToLower(name)
+	if v, ok := f.macros[name]; ok {
+		return v, true
+	}
+	v, ok := monthMacros[name]
+	return v, ok
+}
+
+// MacroNames returns the names of the macros defined in f in the order of
+// their definition.
+func (f *File) MacroNames() []string {
+	return f.macroNames
+}
+
+// setMacro defines or redefines a macro.
+func (f *File) setMacro(name, value string) {
+	if f.macros == nil {
+		f.macros = make(map[string]string)
+	}
+	name = strings.ToLower(name)
+	if _, ok := f.macros[name]; !ok {
+		f.macroNames = append(f.macroNames, name)
+	}
+	f.macros[name] = value
+}
+
+// valuePart is one operand of a # concatenation.
+type valuePart struct {
+	text  string
+	delim Delim // DelimNone for numbers and macro references
+}
+
+func (vp valuePart) isMacro() bool {
+	if vp.delim != DelimNone {
+		return false
+	}
+	for _, c := range []byte(vp.text) {
+		if c < '0' || c > '9' {
+			return true
+		}
+	}
+	return false
+}
+
+func (vp valuePart) String() string {
+	switch vp.delim {
+	case DelimBrace:
+		return "{" + vp.text + "}"
+	case DelimQuote:
+		return `"` + vp.text + `"`
+	}
+	return vp.text
+}
+
+// expand concatenates parts replacing macro references by their values.
+// An undefined macro is kept as is so that no text is lost.
+func (f *File) expand(parts []valuePart) string {
+	var sb strings.Builder
+	for _, vp := range parts {
+		if vp.isMacro() {
+			if v, ok := f.Macro(vp.text); ok {
+				sb.WriteString(v)
+				continue
+			}
+		}
+		sb.WriteString(vp.text)
+	}
+	return sb.String()
+}
+
+// joinParts returns the source form of a value expression.
+func joinParts(parts []valuePart) string {
+	ss := make([]string, len(parts))
+	for i, vp := range parts {
+		ss[i] = vp.String()
+	}
+	return strings.Join(ss, " # ")
+}
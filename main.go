@@ -6,6 +6,7 @@ import (
 	"io"
 	"slices"
 	"strings"
+	"unicode"
 )
 
 type SetActionType int8
@@ -31,6 +32,9 @@ type DedupReport struct {
 	DuplicateSetCount int
 	DuplicateSet      DedupMap
 	ResultSetCount    int
+	// Pairs holds the similarity of the pairs of records that fuzzy
+	// deduplication linked directly, in the order they were linked.
+	Pairs []DupPair
 }
 
 func (dr *DedupReport) Print(w io.Writer) (err error) {
@@ -38,6 +42,10 @@ func (dr *DedupReport) Print(w io.Writer) (err error) {
 		return nil
 	}
 	fmt.Fprintf(w, "%d duplicate sets found\n", dr.DuplicateSetCount)
+	pairs := make(map[string][]DupPair)
+	for _, p := range dr.Pairs {
+		pairs[p.Set] = append(pairs[p.Set], p)
+	}
 	for idxTerm, nodes := range dr.DuplicateSet { //
 		if ndup := len(nodes); ndup > 1 {
 			_, err = fmt.Fprintf(w, "%s\n[%s] has %d occurrences in lines \n", strings.Repeat("*", 60), idxTerm, ndup)
@@ -46,6 +54,10 @@ func (dr *DedupReport) Print(w io.Writer) (err error) {
 				_, err = fmt.Fprintf(w, "%s:%d\n", n.Parent.Name(), n.Node.Line())
 				err = Print(w, n.Node)
 			}
+			for _, p := range pairs[idxTerm] {
+				_, err = fmt.Fprintf(w, "%s:%d ~ %s:%d: %.2f (title %.2f, authors %.2f)\n",
+					p.A.Parent.Name(), p.A.Node.Line(), p.B.Parent.Name(), p.B.Node.Line(), p.Score, p.Title, p.Authors)
+			}
 		}
 	}
 	if err != nil {
@@ -68,10 +80,10 @@ func (dr DedupReport) String() string {
 //	}
 //
 // indexEntry returns a string concating values of fields
-func indexEntry(rec *Record, fldNames []string, raw bool) string {
+func indexEntry(rec *Record, fldNames []string, raw bool, opts DedupOptions) string {
 	var sb strings.Builder
 	for _, fldname := range fldNames {
-		sb.WriteString(rec.Field(fldname))
+		sb.WriteString(rec.MergedField(fldname, opts.Duplicates, opts.DupSep))
 	}
 	if raw {
 		return sb.String()
@@ -83,25 +95,74 @@ func indexEntry(rec *Record, fldNames []string, raw bool) string {
 // using the concatinated values of field names. If no fields specified,
 // citekey is used to deduplicate the set.
 // if no error encountered, it returns a DedupReport struct if action== SetNoAction
-// and additionally a set of processed refs if action != SetNoAction
+// and additionally a set of processed refs if action != SetNoAction.
+// The records of the result are copies, so changing them leaves the input
+// files alone.
 func Deduplicate(files []*File, fldNames []string, action SetActionType) (*File, *DedupReport, error) {
-	if len(files)*files[0].RecordCount() == 0 {
+	return DeduplicateWith(files, fldNames, action, DedupOptions{})
+}
+
+// DedupOptions controls DeduplicateWith.
+type DedupOptions struct {
+	// Duplicates and DupSep tell how the values of a repeated field are
+	// combined before records are compared, as in Options; by default,
+	// the first value is used.
+	Duplicates DupPolicy
+	DupSep     string
+	// Fuzzy finds records whose titles, authors and years are similar
+	// rather than records whose fields are equal; the field names given to
+	// DeduplicateWith are then ignored.
+	Fuzzy bool
+	// TitleThreshold and AuthorThreshold are the similarities, from 0 to
+	// 1, from which the titles and the authors of two records are taken as
+	// the same in fuzzy mode; 0.9 and 0.8 if zero. Titles are compared by
+	// their words, which match when their Jaro-Winkler similarity is high,
+	// authors by the share of the shorter list found in the other one.
+	TitleThreshold, AuthorThreshold float64
+	// YearTolerance is how many years apart the years of two duplicates
+	// may be in fuzzy mode. Records without a year match any year.
+	YearTolerance int
+	// Identifiers first finds records that share a DOI, PMID or ISBN, as
+	// normalised by Record.DOI, PMID and ISBN; the ISBN of a chapter or a
+	// paper in proceedings is ignored. A set of duplicates never holds two
+	// records with identifiers of the same kind unless they share one;
+	// others are compared by their fields, or as in fuzzy mode.
+	Identifiers bool
+}
+
+// DeduplicateWith is like Deduplicate but uses the given options.
+func DeduplicateWith(files []*File, fldNames []string, action SetActionType, opts DedupOptions) (*File, *DedupReport, error) {
+	total := 0
+	for _, f := range files {
+		total += f.RecordCount()
+	}
+	if total == 0 {
 		return nil, nil, fmt.Errorf("nothing to deduplicate")
 	}
 	hasFields := len(fldNames) > 0
 	citekey := !hasFields || slices.Contains(fldNames, "citekey")
 	// print("citekey"); print(citekey)
-	dupSet := make(DedupMap, files[0].RecordCount()*len(files))
-	for _, r := range files {
-		for _, c := range r.Records {
-			idx := ""
-			if hasFields {
-				idx = indexEntry(c, fldNames, false)
-			}
-			if citekey {
-				idx = idx + c.Key()
+	index := func(c *Record) string {
+		idx := ""
+		if hasFields {
+			idx = indexEntry(c, fldNames, false, opts)
+		}
+		if citekey {
+			idx = idx + c.Key()
+		}
+		return idx
+	}
+	var dupSet DedupMap
+	var pairs []DupPair
+	if opts.Fuzzy || opts.Identifiers {
+		dupSet, pairs = linkedSets(files, index, opts)
+	} else {
+		dupSet = make(DedupMap, total)
+		for _, r := range files {
+			for _, c := range r.Records {
+				idx := index(c)
+				dupSet[idx] = append(dupSet[idx], NodeInfo{c, r})
 			}
-			dupSet[idx] = append(dupSet[idx], NodeInfo{c, r})
 		}
 	}
 	duplicateSets := 0
@@ -110,7 +171,7 @@ func Deduplicate(files []*File, fldNames []string, action SetActionType) (*File,
 			duplicateSets++
 		}
 	}
-	dr := &DedupReport{DuplicateSetCount: duplicateSets, DuplicateSet: dupSet}
+	dr := &DedupReport{DuplicateSetCount: duplicateSets, DuplicateSet: dupSet, Pairs: pairs}
 	if action == SetNoAction {
 		return nil, dr, nil
 	}
@@ -121,7 +182,7 @@ func Deduplicate(files []*File, fldNames []string, action SetActionType) (*File,
 		res := newRoot("intersection.bib")
 		for _, recs := range dupSet {
 			if ndup := len(recs); ndup > 1 { //duplicates
-				res.AddRecord(recs[0].Node) //print the first in the set
+				res.AddRecord(recs[0].Node.Clone()) //print the first in the set
 				dr.ResultSetCount++
 			}
 		}
@@ -130,7 +191,7 @@ func Deduplicate(files []*File, fldNames []string, action SetActionType) (*File,
 	if action == SetUnion {
 		res := newRoot("union.bib")
 		for _, recs := range dupSet {
-			res.AddRecord(recs[0].Node)
+			res.AddRecord(recs[0].Node.Clone())
 			dr.ResultSetCount++
 		}
 		return res, dr, nil
@@ -151,13 +212,11 @@ func ValidKeys(n *File) bool {
 // first word of the title + first letter of article type + page or volume #
 func NewCiteKey(rec *Record) string {
 	var sb strings.Builder
-	word, _, found := strings.Cut(rec.Field("author"), ",")
-	if !found {
-		word, _, _ = strings.Cut(rec.Field("author"), " ")
+	if authors := rec.Authors(); len(authors) > 0 {
+		sb.WriteString(keyWord(authors[0].Last))
 	}
-	sb.WriteString(strings.ToLower(word))
-	sb.WriteString(rec.Field("year"))
-	word, _, _ = strings.Cut(rec.Field("title"), " ")
+	sb.WriteString(rec.yearText())
+	word, _, _ := strings.Cut(rec.Field("title"), " ")
 	sb.WriteString(strings.ToLower(word))
 	b := byte('x')
 	if rec.value != "" {
@@ -168,6 +227,17 @@ func NewCiteKey(rec *Record) string {
 	return sb.String()
 }
 
+// keyWord turns a surname into a part of a citation key: lower case,
+// without LaTeX, spaces or punctuation.
+func keyWord(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, plainText(s))
+}
+
 // Fixkeys ensures that every record has a unique key
 // contents of fldnames will be used to create a unique key
 // with a,b,c etc added to ensure uniqueness; if len(fldnames)== 0
@@ -175,12 +245,13 @@ func NewCiteKey(rec *Record) string {
 // all keys are replaced not just duplicate records
 func FixKeys(f *File, fldnames []string, all bool) (*DedupReport, error) {
 	useStd := len(fldnames) == 0
+	f.index = nil
 	for _, rec := range f.Records {
 		if all || rec.key == "" {
 			if useStd {
 				rec.key = NewCiteKey(rec)
 			} else {
-				rec.key = indexEntry(rec, fldnames, false)
+				rec.key = indexEntry(rec, fldnames, false, DedupOptions{})
 			}
 		}
 	}
@@ -250,56 +321,3 @@ func Split(f *File) map[string]*File {
 	}
 	return res
 }
-
-// TODO: process tex
-// see https://github.com/aclements/biblib/blob/ab0e857b9198fe425ec9b02fcc293b5d9fd0c406/biblib/algo.py#L327
-
-//translations for latex escapes
-//         _latex.Add("\\`{o}", "ò");
-//         _latex.Add("\\'{o}", "ó");
-//         _latex.Add("\\^{o}", "ô");
-//         _latex.Add("\\\"{o}", "ö");
-//         _latex.Add("\\H{o}", "ő");
-//         _latex.Add("\\~{o}", "õ");
-//         _latex.Add("\\c{c}", "ç");
-//         _latex.Add("\\k{a}", "ą");
-//         _latex.Add("\\l{}", "ł");
-//         _latex.Add("\\={o}", "ō");
-//         _latex.Add("\\b{o}", "o");
-//         _latex.Add("\\.{o}", "ȯ");
-//         _latex.Add("\\d{u}", "ụ");
-//         _latex.Add("\\r{a}", "å");
-//         _latex.Add("\\u{o}", "ŏ");
-//         _latex.Add("\\v{s}", "š");
-//         _latex.Add("\\t{oo}", "o͡o");
-//         _latex.Add("\\o", "ø");
-
-//         _latex.Add("\\%", "%");
-//         _latex.Add("\\$", "$");
-//         _latex.Add("\\{", "{");
-//         _latex.Add("\\_", "_");
-//         _latex.Add("\\P", "¶");
-//         _latex.Add("\\ddag", "‡");
-//         _latex.Add("\\textbar", "|");
-//         _latex.Add("\\textgreater", ">");
-//         _latex.Add("\\textendash", "–");
-//         _latex.Add("\\texttrademark", "™");
-//         _latex.Add("\\textexclamdown", "¡");
-//         _latex.Add("\\textsuperscript{ a}", "a");
-//         _latex.Add("\\pounds", "£");
-//         _latex.Add("\\#", "#");
-//         _latex.Add("\\&", "&");
-//         _latex.Add("\\}", "}");
-//         _latex.Add("\\S", "§");
-//         _latex.Add("\\dag", "†");
-//         _latex.Add("\\textbackslash", "\\");
-//         _latex.Add("\\textless", "<");
-//         _latex.Add("\\textemdash", "—");
-//         _latex.Add("\\textregistered", "®");
-//         _latex.Add("\\textquestiondown", "¿");
-//         _latex.Add("\\textcircled{ a}", "ⓐ");
-//         _latex.Add("\\copyright", "©");
-
-//         _latex.Add("$\\backslash$", "\\");
-//         _latex.Add("\\'{e}", "ë");
-//         _latex.Add("\\'{i}", "ë");
@@ -0,0 +1,405 @@
+package bibsin
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Person is a name from an author or editor list. Parts are kept as
+// written, LaTeX included.
+type Person struct {
+	First    string   // given names
+	Von      string   // particles such as "van der"
+	Last     string   // surname
+	Jr       string   // suffix such as "Jr." or "III"
+	Initials string   // initials of the given names, e.g. "SM"
+	Markers  []string // annotations in square brackets, e.g. "S" for "[S]"
+}
+
+// IsOthers reports whether p stands for "and others" (et al.).
+func (p Person) IsOthers() bool {
+	return p.Last == "others" && p.First == "" && p.Von == "" && p.Initials == ""
+}
+
+// Authors returns the names in the author field of rec.
+func (rec *Record) Authors() []Person {
+	return ParseNames(rec.Field("author"))
+}
+
+// Editors returns the names in the editor field of rec.
+func (rec *Record) Editors() []Person {
+	return ParseNames(rec.Field("editor"))
+}
+
+// ParseNames splits a list of names joined by "and" and parses each of
+// them with ParseName.
+func ParseNames(s string) []Person {
+	var names []Person
+	for _, name := range splitNames(s) {
+		if p := ParseName(name); p.Last != "" || p.First != "" || p.Initials != "" {
+			names = append(names, p)
+		}
+	}
+	return names
+}
+
+// splitNames splits s at the word "and", in any case, outside braces.
+func splitNames(s string) []string {
+	var names []string
+	words := nameWords(s)
+	start := 0
+	for _, w := range words {
+		if strings.EqualFold(w.text, "and") {
+			names = append(names, s[start:w.start])
+			start = w.end
+		}
+	}
+	return append(names, s[start:])
+}
+
+type nameWord struct {
+	text       string
+	start, end int // offsets in the name
+}
+
+// nameWords splits s into words at white space, tildes and commas outside
+// braces. Commas are returned as words of their own.
+func nameWords(s string) []nameWord {
+	var words []nameWord
+	depth, start := 0, -1
+	flush := func(end int) {
+		if start >= 0 {
+			words = append(words, nameWord{s[start:end], start, end})
+			start = -1
+		}
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == LBRACE:
+			depth++
+		case c == RBRACE:
+			if depth > 0 {
+				depth--
+			}
+		case depth > 0:
+		case isSpace(c) || c == '~':
+			flush(i)
+			continue
+		case c == COMMA:
+			flush(i)
+			words = append(words, nameWord{",", i, i + 1})
+			continue
+		}
+		if start < 0 {
+			start = i
+		}
+	}
+	flush(len(s))
+	return words
+}
+
+// ParseName parses a single name following the rules of BibTeX: "First von
+// Last", "von Last, First" or "von Last, Jr, First", where the von part is
+// made of the words that start with a lower case letter. It also accepts the
+// style of PubMed and the Canadian Common CV, where the initials of the
+// given names follow the surname: "Van Aalst R", "Singh H." or "Mahmud SM
+// Jr". Words in square brackets, such as the [S] that marks a student, are
+// returned as Markers.
+func ParseName(s string) Person {
+	var p Person
+	var parts [][]string // words separated by commas
+	var words []string
+	for _, w := range nameWords(s) {
+		switch {
+		case w.text == ",":
+			parts = append(parts, words)
+			words = nil
+		case len(w.text) > 2 && w.text[0] == '[' && w.text[len(w.text)-1] == ']':
+			p.Markers = append(p.Markers, w.text[1:len(w.text)-1])
+		default:
+			words = append(words, w.text)
+		}
+	}
+	parts = append(parts, words)
+	switch {
+	case len(parts) == 1 && len(parts[0]) == 1 && parts[0][0] == "others":
+		p.Last = "others"
+		return p
+	case len(parts) == 1:
+		words = parts[0]
+		if n := len(words); n > 2 && isJr(words[n-1]) {
+			if _, k := trailingInitials(words[:n-1]); k > 0 {
+				p.Jr = words[n-1]
+				words = words[:n-1]
+			}
+		}
+		if initials, k := trailingInitials(words); k > 0 {
+			p.Initials = initials
+			p.Von, p.Last = splitVon(words[:len(words)-k], true)
+			return p
+		}
+		// First von Last: von starts at the first lower case word
+		// that is not the last word
+		i := 0
+		for i < len(words)-1 && !isLower(words[i]) {
+			i++
+		}
+		p.First = strings.Join(words[:i], " ")
+		p.Von, p.Last = splitVon(words[i:], false)
+	default:
+		p.Von, p.Last = splitVon(parts[0], false)
+		first := parts[len(parts)-1]
+		if len(parts) > 2 {
+			p.Jr = strings.Join(parts[1], " ")
+		}
+		if len(first) == 1 && len(first[0]) > 1 && isInitials(first[0]) {
+			// Mahmud, SM
+			p.Initials = first[0]
+			return p
+		}
+		p.First = strings.Join(first, " ")
+	}
+	p.Initials = initials(p.First)
+	return p
+}
+
+// splitVon splits the words of "von Last" into its two parts. The von
+// part is the longest run of words, except the last one, that ends with a
+// lower case word; if leading is true, only a run of lower case words at
+// the start counts.
+func splitVon(words []string, leading bool) (von, last string) {
+	i := 0
+	for j := 0; j < len(words)-1; j++ {
+		if isLower(words[j]) {
+			i = j + 1
+		} else if leading {
+			break
+		}
+	}
+	return strings.Join(words[:i], " "), strings.Join(words[i:], " ")
+}
+
+// trailingInitials returns the initials in the words at the end of words,
+// but not in the first word, and the number of those words: "Mahmud SM",
+// "Singh H." and "Montalban J. M." all have initials. Together they may
+// hold up to four letters.
+func trailingInitials(words []string) (initials string, n int) {
+	for i := len(words) - 1; i > 0; i-- {
+		w, ok := initialsOf(words[i])
+		if !ok || len(w)+len(initials) > 4 {
+			break
+		}
+		initials = w + initials
+		n++
+	}
+	return initials, n
+}
+
+var jrWords = map[string]bool{"jr": true, "jr.": true, "sr": true, "sr.": true, "ii": true, "iii": true, "iv": true}
+
+func isJr(w string) bool {
+	return jrWords[strings.ToLower(w)]
+}
+
+// isInitials reports whether w is a run of up to four capitals, as in
+// "Mahmud SM".
+func isInitials(w string) bool {
+	if w == "" || len(w) > 4 {
+		return false
+	}
+	for i := 0; i < len(w); i++ {
+		if w[i] < 'A' || w[i] > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+// initialsOf returns the letters of w and true if w is initials, either
+// undotted as for isInitials or each followed by a period, as in "H.",
+// "J.M." or "J.-M.".
+func initialsOf(w string) (string, bool) {
+	if isInitials(w) {
+		return w, true
+	}
+	var b []byte
+	for i := 0; i < len(w); i++ {
+		if w[i] < 'A' || w[i] > 'Z' || i+1 == len(w) || w[i+1] != '.' {
+			return "", false
+		}
+		b = append(b, w[i])
+		i++
+		if i+2 < len(w) && w[i+1] == '-' {
+			i++
+		}
+	}
+	return string(b), b != nil
+}
+
+// isLower reports whether the first letter of w is lower case. A letter
+// produced by a LaTeX command counts, so {\'e}tienne is lower case; any
+// other braced text is not.
+func isLower(w string) bool {
+	r, _ := firstLetter(w)
+	return unicode.IsLower(r)
+}
+
+// firstLetter returns the first letter of w, looking into the LaTeX
+// special characters at brace depth 1, and whether it was found.
+func firstLetter(w string) (rune, bool) {
+	for i := 0; i < len(w); {
+		r, size := utf8.DecodeRuneInString(w[i:])
+		switch {
+		case r == '{' && strings.HasPrefix(w[i:], "{\\"):
+			return commandLetter(w[i+2:])
+		case r == '{':
+			// protected text is caseless
+			return 0, false
+		case unicode.IsLetter(r):
+			return r, true
+		}
+		i += size
+	}
+	return 0, false
+}
+
+// commandLetter returns the letter that the LaTeX command at the start of
+// s stands for: the argument of an accent such as \'e or \c{c}, or the first
+// letter of a command such as \o or \ss.
+func commandLetter(s string) (rune, bool) {
+	name := s
+	if i := strings.IndexFunc(s, func(r rune) bool { return !unicode.IsLetter(r) }); i > 0 {
+		name = s[:i]
+	} else if i == 0 {
+		name = s[:1]
+	}
+	rest := strings.TrimLeft(s[len(name):], " {")
+	if r, _ := utf8.DecodeRuneInString(rest); unicode.IsLetter(r) {
+		return r, true
+	}
+	if r, _ := utf8.DecodeRuneInString(name); unicode.IsLetter(r) {
+		return r, true
+	}
+	return 0, false
+}
+
+// initials returns the initials of given names: the first letter of each
+// name, or of each part of a hyphenated or abbreviated name.
+func initials(first string) string {
+	var sb strings.Builder
+	for _, w := range strings.FieldsFunc(first, func(r rune) bool { return r == ' ' || r == '-' || r == '.' }) {
+		if r, ok := firstLetter(w); ok {
+			sb.WriteRune(unicode.ToUpper(r))
+		}
+	}
+	return sb.String()
+}
+
+// plainText removes the braces and LaTeX commands of s, keeping the
+// letters they apply to.
+func plainText(s string) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '{' || c == '}':
+		case c == '\\':
+			// skip the name of the command
+			j := i + 1
+			for j < len(s) && (s[j] >= 'a' && s[j] <= 'z' || s[j] >= 'A' && s[j] <= 'Z') {
+				j++
+			}
+			if j == i+1 && j < len(s) {
+				j++ // an accent such as \'
+			}
+			i = j - 1
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String()
+}
+
+// NameStyle selects how names are written by Person.Format and the
+// exporters.
+type NameStyle int8
+
+const (
+	NamesAsIs      NameStyle = iota // the field as written in the record
+	NamesVancouver                  // Okoli GN
+	NamesAPA                        // Okoli, G. N.
+	NamesFull                       // George N. Okoli
+	NamesLastFirst                  // Okoli, George N.
+)
+
+// dotted writes initials such as "GN" as "G. N.".
+func dotted(initials string) string {
+	var parts []string
+	for _, r := range initials {
+		parts = append(parts, string(r)+".")
+	}
+	return strings.Join(parts, " ")
+}
+
+// join joins the non-empty strings in parts with sep.
+func join(sep string, parts ...string) string {
+	var res []string
+	for _, s := range parts {
+		if s != "" {
+			res = append(res, s)
+		}
+	}
+	return strings.Join(res, sep)
+}
+
+// Format writes p in the given style. Given names that are only known by
+// their initials are written as initials in every style. Markers follow
+// the name in square brackets.
+func (p Person) Format(style NameStyle) string {
+	if p.IsOthers() {
+		return "et al."
+	}
+	last := join(" ", p.Von, p.Last)
+	first := p.First
+	if first == "" {
+		first = dotted(p.Initials)
+	}
+	var s string
+	switch style {
+	case NamesVancouver:
+		s = join(" ", last, p.Initials, p.Jr)
+	case NamesAPA:
+		s = join(", ", last, dotted(p.Initials), p.Jr)
+	case NamesFull:
+		s = join(" ", first, last, p.Jr)
+	default:
+		s = join(", ", last, first, p.Jr)
+	}
+	for _, m := range p.Markers {
+		s += " [" + m + "]"
+	}
+	return s
+}
+
+// FormatNames writes a list of names in the given style: separated by
+// commas in the Vancouver style, with an ampersand before the last name in
+// the APA style and with "and" before the last name otherwise.
+func FormatNames(names []Person, style NameStyle) string {
+	var sb strings.Builder
+	for i, p := range names {
+		if i > 0 {
+			switch {
+			case style == NamesVancouver || p.IsOthers() || i < len(names)-1:
+				sb.WriteString(", ")
+			case style == NamesAPA:
+				sb.WriteString(", & ")
+			default:
+				sb.WriteString(" and ")
+			}
+		}
+		sb.WriteString(p.Format(style))
+	}
+	return sb.String()
+}
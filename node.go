@@ -3,16 +3,72 @@ package bibsin
 import (
 	"fmt"
 	"io"
+	"slices"
 	"strings"
 )
 
+// Pos is a position in the source of a file.
+type Pos struct {
+	Line   int `json:"line"`   // starting at 1
+	Column int `json:"column"` // starting at 1, counted in characters
+	Offset int `json:"offset"` // in bytes, starting at 0
+}
+
+func (pos Pos) String() string {
+	return fmt.Sprintf("%d:%d", pos.Line, pos.Column)
+}
+
+// Span is the source text from Start up to, but excluding, End.
+type Span struct {
+	Start Pos `json:"start"`
+	End   Pos `json:"end"`
+}
+
+// Node is an element of a bibtex file: a *Record, *Comment, *Preamble,
+// *MacroDef or *Junk.
+type Node interface {
+	Line() int
+}
+
 type File struct {
-	Records []*Record
-	name    string
+	Records    []*Record
+	nodes      []Node // all nodes in source order; see Nodes
+	name       string
+	macros     map[string]string // @string definitions by lowercase name
+	macroNames []string          // macro names in order of definition
+	index      map[string]int    // see keyIndex
+	indexed    int               // number of records when index was built
 }
 
 func (f *File) AddRecord(rec *Record) {
 	f.Records = append(f.Records, rec)
+	f.nodes = append(f.nodes, rec)
+}
+
+func (f *File) addNode(n Node) {
+	f.nodes = append(f.nodes, n)
+}
+
+// Nodes returns the records of f interleaved with the comments, preambles,
+// macro definitions and junk text that surround them. Records keep the
+// places they had in the source but are taken in order from f.Records, so
+// that sorting Records leaves the other nodes in place; records added to
+// f.Records directly are put at the end.
+func (f *File) Nodes() []Node {
+	nodes := make([]Node, 0, len(f.nodes))
+	k := 0
+	for _, n := range f.nodes {
+		if _, ok := n.(*Record); !ok {
+			nodes = append(nodes, n)
+		} else if k < len(f.Records) {
+			nodes = append(nodes, f.Records[k])
+			k++
+		}
+	}
+	for _, rec := range f.Records[k:] {
+		nodes = append(nodes, rec)
+	}
+	return nodes
 }
 
 func (f *File) RecordCount() int {
@@ -31,13 +87,34 @@ type Record struct {
 	fields []Field
 	key    string // citation key; ROOT for root node
 	value  string // bibtex type; filenamme for root node
+	parens bool   // delimited by ( ) rather than { }
 	line   int
+	// source spans of the whole record, its type and its key
+	span, typeSpan, keySpan Span
+	syn                     *recordSyntax // source layout kept in lossless mode
+	inherited               []Field       // fields inherited, see File.Resolve
 }
 
 func (rec *Record) Line() int {
 	return rec.line
 }
 
+// Span returns the source span of the record from @ to its closing
+// delimiter.
+func (rec *Record) Span() Span {
+	return rec.span
+}
+
+// TypeSpan returns the source span of the entry type.
+func (rec *Record) TypeSpan() Span {
+	return rec.typeSpan
+}
+
+// KeySpan returns the source span of the citation key.
+func (rec *Record) KeySpan() Span {
+	return rec.keySpan
+}
+
 func (rec *Record) Key() string {
 	return rec.key
 }
@@ -45,6 +122,11 @@ func (rec *Record) Value() string {
 	return rec.value
 }
 
+// Parens reports whether the record was delimited by parentheses.
+func (rec *Record) Parens() bool {
+	return rec.parens
+}
+
 func (rec *Record) BibtexRepr() string {
 	return fmt.Sprintf("\n@%s{%s,\n", rec.value, rec.key)
 }
@@ -57,25 +139,189 @@ func (n *Record) addField(c Field) {
 	n.fields = append(n.fields, c)
 }
 
-func (n *Record) Field(fieldName string) string {
-	for _, fld := range n.fields {
-		if fld.key == fieldName {
-			return fld.value
+// lookup returns the field of rec named name regardless of case, or nil.
+func (rec *Record) lookup(name string) *Field {
+	for i := range rec.fields {
+		if strings.EqualFold(rec.fields[i].key, name) {
+			return &rec.fields[i]
 		}
 	}
+	return nil
+}
+
+// Field returns the value of the field fieldName, ignoring case, or "" if
+// there is none. If the field is repeated, the first value is returned.
+func (n *Record) Field(fieldName string) string {
+	if fld := n.lookup(fieldName); fld != nil {
+		return fld.value
+	}
 	return ""
 }
 
+// FieldValues returns the values of every field called fieldName, ignoring
+// case, in order.
+func (rec *Record) FieldValues(fieldName string) []string {
+	var values []string
+	for _, fld := range rec.fields {
+		if strings.EqualFold(fld.key, fieldName) {
+			values = append(values, fld.value)
+		}
+	}
+	return values
+}
+
+// MergedField returns the values of the field fieldName combined according
+// to policy; sep separates joined values and is "; " if empty.
+func (rec *Record) MergedField(fieldName string, policy DupPolicy, sep string) string {
+	return joinValues(rec.FieldValues(fieldName), policy, sep)
+}
+
+// Repeated returns the names of the fields that occur more than once in
+// rec, in lower case and in order of first occurrence.
+func (rec *Record) Repeated() []string {
+	var names []string
+	seen := make(map[string]int, len(rec.fields))
+	for _, fld := range rec.fields {
+		name := strings.ToLower(fld.key)
+		seen[name]++
+		if seen[name] == 2 {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// NewRecord returns an empty record of type typ with citation key key.
+func NewRecord(typ, key string) *Record {
+	return &Record{value: typ, key: key}
+}
+
+// SetKey changes the citation key of rec.
+func (rec *Record) SetKey(key string) {
+	rec.key = key
+}
+
+// SetType changes the entry type of rec.
+func (rec *Record) SetType(typ string) {
+	rec.value = typ
+}
+
+// Fields returns a copy of the fields of rec in order.
+func (rec *Record) Fields() []Field {
+	return append([]Field(nil), rec.fields...)
+}
+
+// HasField reports whether rec has a field called name, ignoring case.
+func (rec *Record) HasField(name string) bool {
+	return rec.lookup(name) != nil
+}
+
+// SetField sets the value of the field name, ignoring case, or adds the
+// field at the end of rec if there is none. A bare value that is no longer
+// a number is written in braces.
+func (rec *Record) SetField(name, value string) {
+	fld := rec.lookup(name)
+	if fld == nil {
+		rec.addField(Field{key: name, value: value})
+		return
+	}
+	fld.setValue(value)
+}
+
+// setValue changes the value of fld, dropping its source form. A bare
+// value that is no longer a number is written in braces.
+func (fld *Field) setValue(value string) {
+	fld.value, fld.raw = value, ""
+	if fld.delim == DelimNone && !isNumber(value) {
+		fld.delim = DelimBrace
+	}
+}
+
+// DeleteField removes every field called name, ignoring case, and reports
+// whether there was any.
+func (rec *Record) DeleteField(name string) bool {
+	n := len(rec.fields)
+	rec.fields = slices.DeleteFunc(rec.fields, func(fld Field) bool {
+		return strings.EqualFold(fld.key, name)
+	})
+	return len(rec.fields) < n
+}
+
+// RenameField renames every field called oldName, ignoring case, to
+// newName and reports whether there was any.
+func (rec *Record) RenameField(oldName, newName string) bool {
+	found := false
+	for i := range rec.fields {
+		if strings.EqualFold(rec.fields[i].key, oldName) {
+			rec.fields[i].key = newName
+			found = true
+		}
+	}
+	return found
+}
+
+// ReorderFields moves the fields called names, ignoring case, to the front
+// of rec in that order. Other fields keep their order after them.
+func (rec *Record) ReorderFields(names ...string) {
+	rank := func(fld Field) int {
+		for i, name := range names {
+			if strings.EqualFold(fld.key, name) {
+				return i
+			}
+		}
+		return len(names)
+	}
+	slices.SortStableFunc(rec.fields, func(a, b Field) int {
+		return rank(a) - rank(b)
+	})
+}
+
+func isNumber(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// Delim is the style used to delimit a field value.
+type Delim int8
+
+const (
+	DelimBrace Delim = iota // {value}
+	DelimQuote              // "value"
+	DelimNone               // bare number such as 2019
+)
+
 type Field struct {
 	key   string // name of field
 	value string // value of field
+	delim Delim  // how value was delimited in the source
+	raw   string // unexpanded value expression, if kept
 	line  int
+	// source spans of the name and of the value including its delimiters
+	nameSpan, valueSpan Span
+	syn                 *fieldSyntax // source layout kept in lossless mode
 }
 
 func (rec *Field) Line() int {
 	return rec.line
 }
 
+// NameSpan returns the source span of the field name.
+func (rec *Field) NameSpan() Span {
+	return rec.nameSpan
+}
+
+// ValueSpan returns the source span of the value including its delimiters.
+func (rec *Field) ValueSpan() Span {
+	return rec.valueSpan
+}
+
 func (rec *Field) Key() string {
 	return rec.key
 }
@@ -83,35 +329,185 @@ func (rec *Field) Value() string {
 	return rec.value
 }
 
+// Delim returns the delimiter style of the value.
+func (rec *Field) Delim() Delim {
+	return rec.delim
+}
+
+// Raw returns the unexpanded form of a value that uses macros or #
+// concatenation if it was kept by Parse, and "" otherwise.
+func (rec *Field) Raw() string {
+	return rec.raw
+}
+
 func (rec *Field) BibtexRepr() string {
+	if rec.raw != "" {
+		return fmt.Sprintf("%s=%s", rec.key, rec.raw)
+	}
+	switch rec.delim {
+	case DelimQuote:
+		return fmt.Sprintf("%s=\"%s\"", rec.key, rec.value)
+	case DelimNone:
+		return fmt.Sprintf("%s=%s", rec.key, rec.value)
+	}
 	return fmt.Sprintf("%s={%s}", rec.key, rec.value)
 }
 
+// Comment is an @comment entry.
+type Comment struct {
+	text string
+	src  string // source kept in lossless mode
+	line int
+}
+
+func (c *Comment) Line() int {
+	return c.line
+}
+
+func (c *Comment) Text() string {
+	return c.text
+}
+
+func (c *Comment) BibtexRepr() string {
+	return fmt.Sprintf("@comment{%s}", c.text)
+}
+
+// Preamble is a @preamble entry; its text is kept as written.
+type Preamble struct {
+	text string
+	src  string // source kept in lossless mode
+	line int
+}
+
+func (pr *Preamble) Line() int {
+	return pr.line
+}
+
+func (pr *Preamble) Text() string {
+	return pr.text
+}
+
+func (pr *Preamble) BibtexRepr() string {
+	return fmt.Sprintf("@preamble{%s}", pr.text)
+}
+
+// MacroDef is a @string entry defining a macro.
+type MacroDef struct {
+	field Field
+	src   string // source kept in lossless mode
+	line  int
+}
+
+func (m *MacroDef) Line() int {
+	return m.line
+}
+
+// Name returns the name of the macro.
+func (m *MacroDef) Name() string {
+	return m.field.key
+}
+
+// Value returns the expanded value of the macro.
+func (m *MacroDef) Value() string {
+	return m.field.value
+}
+
+func (m *MacroDef) BibtexRepr() string {
+	return fmt.Sprintf("@string{%s}", m.field.BibtexRepr())
+}
+
+// Junk is text found between entries, such as % comments.
+type Junk struct {
+	text string
+	src  string // source kept in lossless mode
+	line int
+}
+
+func (j *Junk) Line() int {
+	return j.line
+}
+
+func (j *Junk) Text() string {
+	return j.text
+}
+
+// PrintOptions controls how Print writes bibtex.
+type PrintOptions struct {
+	// Parens writes records that were delimited by parentheses in the
+	// source the same way; otherwise all records use braces.
+	Parens bool
+	// Inherit also writes the fields that records inherit from other
+	// entries, as found by File.Resolve.
+	Inherit bool
+	// EncodeLaTeX writes field values as LaTeX, as Record.EncodeLaTeX
+	// does, except those of verbatim fields such as url and doi and those
+	// kept with their macros.
+	EncodeLaTeX bool
+}
+
 func Print(w io.Writer, n any) error {
+	return PrintWith(w, n, PrintOptions{})
+}
+
+// PrintWith is like Print but uses the given options.
+func PrintWith(w io.Writer, n any, opts PrintOptions) error {
 	//FIXME: check for errors
 	switch n := n.(type) {
 	case *File:
-		for _, c := range n.Records {
-			Print(w, c)
+		for _, c := range n.Nodes() {
+			PrintWith(w, c, opts)
 		}
 		return nil
 	case *Record:
-		fmt.Fprintf(w, n.BibtexRepr())
-		for i, c := range n.fields {
-			Print(w, c)
-			if i < len(n.fields) {
+		fields := n.fields
+		if opts.Inherit {
+			fields = n.allFields()
+		}
+		if opts.EncodeLaTeX {
+			fields = slices.Clone(fields)
+			transformFields(fields, encodeValue)
+		}
+		if n.syn != nil {
+			return n.writeSyntax(w, fields)
+		}
+		open, close := "{", "}"
+		if opts.Parens && n.parens {
+			open, close = "(", ")"
+		}
+		fmt.Fprintf(w, "\n@%s%s%s,\n", n.value, open, n.key)
+		for i, c := range fields {
+			PrintWith(w, c, opts)
+			if i < len(fields) {
 				fmt.Fprintln(w, ",")
 			}
 		}
-		fmt.Fprintln(w, "}")
+		fmt.Fprintln(w, close)
 	case Field:
-		fmt.Fprintf(w, n.BibtexRepr())
+		fmt.Fprint(w, n.BibtexRepr())
+	case *Comment:
+		writeNode(w, n.src, n.BibtexRepr())
+	case *Preamble:
+		writeNode(w, n.src, n.BibtexRepr())
+	case *MacroDef:
+		writeNode(w, n.src, n.BibtexRepr())
+	case *Junk:
+		writeNode(w, n.src, n.text)
 	default:
 		return fmt.Errorf("Unknown Node type")
 	}
 	return nil
 }
 
+// writeNode writes the source of a node if it was kept and its bibtex
+// representation otherwise.
+func writeNode(w io.Writer, src, repr string) {
+	if src != "" {
+		io.WriteString(w, src)
+		return
+	}
+	fmt.Fprintf(w, "\n%s\n", repr)
+}
+
 const typBiblioTemplateBegin = `= %s
 #enum(
   start: 1,
@@ -171,7 +567,59 @@ const typBiblioTemplateBegin = `= %s
 // keywords={online resources},
 // }
 
+// ExportOptions controls the exporters.
+type ExportOptions struct {
+	// Inherit includes the fields that records inherit from other
+	// entries, as found by File.Resolve.
+	Inherit bool
+	// Duplicates and DupSep tell how the values of a repeated field are
+	// combined, as in Options; by default, the first value is used.
+	Duplicates DupPolicy
+	DupSep     string
+	// Names is the style of author names.
+	Names NameStyle
+	// DecodeLaTeX writes values as Unicode text, as DecodeLaTeX does, and
+	// drops the braces that protect their case.
+	DecodeLaTeX bool
+}
+
+// names returns the names in field name of rec to export.
+func (opts ExportOptions) names(rec *Record, name string) string {
+	s := opts.value(rec, name)
+	if opts.Names != NamesAsIs {
+		s = FormatNames(ParseNames(s), opts.Names)
+	}
+	return opts.text(name, s)
+}
+
+// field returns the value of the field name of rec to export.
+func (opts ExportOptions) field(rec *Record, name string) string {
+	return opts.text(name, opts.value(rec, name))
+}
+
+// text returns the value s of field name as written by the exporter.
+func (opts ExportOptions) text(name, s string) string {
+	if !opts.DecodeLaTeX || isVerbatim(name) {
+		return s
+	}
+	return decodeLaTeX(s, false)
+}
+
+// value returns the value of the field name of rec, merged and inherited as
+// asked.
+func (opts ExportOptions) value(rec *Record, name string) string {
+	if v := rec.MergedField(name, opts.Duplicates, opts.DupSep); v != "" || !opts.Inherit {
+		return v
+	}
+	return rec.ResolvedField(name)
+}
+
 func AsTyp(w io.Writer, f *File, title string) (err error) {
+	return AsTypWith(w, f, title, ExportOptions{})
+}
+
+// AsTypWith is like AsTyp but uses the given options.
+func AsTypWith(w io.Writer, f *File, title string, opts ExportOptions) (err error) {
 	if _, err = fmt.Fprintf(w, typBiblioTemplateBegin, title, f.RecordCount()); err != nil {
 		return err
 	}
@@ -196,19 +644,26 @@ func AsTyp(w io.Writer, f *File, title string) (err error) {
 	}
 	s := ""
 	for _, c := range f.Records {
+		field := func(name string) string { return opts.field(c, name) }
 		sb.Reset()
 		typ := c.value
 		sb.WriteByte('[') //start typst array entry
-		if s = c.Field("author"); strings.HasPrefix(s, "Anonymous") {
+		if s = opts.names(c, "author"); strings.HasPrefix(s, "Anonymous") {
 			s = ""
 		}
 		writeNotEmpty(s, "", "")
-		writeNotEmpty(c.Field("title"), "_", "_")
-		writeNotEmpty(c.Field("year"), "* (", ")* ")
+		writeNotEmpty(field("title"), "_", "_")
+		if s = field("year"); s == "" {
+			s = c.yearText()
+		}
+		writeNotEmpty(s, "* (", ")* ")
 		switch typ {
 		case "journal":
-			writeNotEmpty(c.Field("journal"), "#underline[ ", "]. ")
-			vol, issue, pages := c.Field("volume"), c.Field("issue"), c.Field("pages")
+			writeNotEmpty(field("journal"), "#underline[ ", "]. ")
+			vol, issue, pages := field("volume"), field("issue"), field("pages")
+			if issue == "" {
+				issue = field("number") // as Record.Issue
+			}
 			s = strings.TrimSpace(issue)
 			if s != "" {
 				s = " (" + s + ") "
@@ -216,20 +671,20 @@ func AsTyp(w io.Writer, f *File, title string) (err error) {
 			sb.WriteString(vol + s + pages)
 			sb.WriteByte('.')
 		case "report":
-			writeNotEmpty(c.Field("institution"), "", "")
+			writeNotEmpty(field("institution"), "", "")
 		case "inbook":
-			writeNotEmpty(c.Field("booktitle"), "in ", "")
-			writeNotEmpty(c.Field("edition"), "", " ed.")
-			notEmpty := writeNotEmpty(c.Field("publisher"), "", "")
+			writeNotEmpty(field("booktitle"), "in ", "")
+			writeNotEmpty(field("edition"), "", " ed.")
+			notEmpty := writeNotEmpty(field("publisher"), "", "")
 			if notEmpty {
-				writeNotEmpty(c.Field("location"), "", "")
+				writeNotEmpty(field("location"), "", "")
 			}
 		case "presentation":
-			writeNotEmpty(c.Field("howpublished"), "", "")
-			writeNotEmpty(c.Field("address"), "", "")
+			writeNotEmpty(field("howpublished"), "", "")
+			writeNotEmpty(field("address"), "", "")
 		}
-		writeNotEmpty(c.Field("doi"), "", "")
-		writeNotEmpty(c.Field("url"), "", "")
+		writeNotEmpty(field("doi"), "", "")
+		writeNotEmpty(field("url"), "", "")
 		sb.WriteString(".],")
 		if _, err = fmt.Fprintln(w, sb.String()); err != nil {
 			return nil
@@ -1,33 +1,118 @@
 package bibsin
 
 import (
-	"bufio"
-	"bytes"
 	"fmt"
 	"io"
 	"os"
+	"slices"
 	"strings"
 )
 
 const (
-	LPAREN     rune = '('
-	RPAREN     rune = ')'
-	LBRACE     byte = '{'
-	RBRACE     byte = '}'
-	LBRACK     rune = '['
-	RBRACK     rune = ']'
-	COMMA      byte = ','
-	COLON      rune = ':'
-	SEMICOLON  rune = ';'
-	EQUAL      byte = '='
-	AT         byte = '@'
+	LPAREN    byte = '('
+	RPAREN    byte = ')'
+	LBRACE    byte = '{'
+	RBRACE    byte = '}'
+	LBRACK    rune = '['
+	RBRACK    rune = ']'
+	COMMA     byte = ','
+	COLON     rune = ':'
+	SEMICOLON rune = ';'
+	EQUAL     byte = '='
+	AT        byte = '@'
+	HASH      byte = '#'
+	QUOTE     byte = '"'
 )
 
 type Options struct {
+	// KeepMacros keeps the source form of values that use macros or #
+	// concatenation so that Print writes them back unexpanded.
+	KeepMacros bool
+	// Lossless keeps the concrete syntax of the input (white space,
+	// delimiters, field order, junk) so that Print writes an unmodified
+	// File byte for byte and a modified record changes only its own lines.
+	Lossless bool
+	// Strict stops parsing at the first problem. By default, Parse skips
+	// malformed entries and carries on with the next one.
+	Strict bool
+	// Case tells how the case of entry types and field names is treated.
+	Case CaseMode
+	// Duplicates tells what to do with a field that occurs more than once
+	// in a record: keep every occurrence or merge them into the first one.
+	// Only DupKeepAll keeps a lossless parse exact.
+	Duplicates DupPolicy
+	// DupSep separates the values joined by DupConcat and
+	// DupConcatReverse; "; " if empty.
+	DupSep string
+	// NormalizeSpace collapses runs of white space, line breaks included,
+	// in field values to single spaces.
+	NormalizeSpace bool
+	// MaxRecordSize, if positive, is the largest entry in bytes that Parse
+	// accepts. Longer entries, typically a value missing its closing brace,
+	// are reported and skipped.
+	MaxRecordSize int
+	// Types, if not empty, lists the entry types to keep; records of other
+	// types are dropped (kept as junk in lossless mode). Case is ignored.
+	Types []string
+	// DropComments discards @comment entries. It has no effect in lossless
+	// mode.
+	DropComments bool
+	// Encoding is the character encoding of the input. A byte order mark
+	// overrides it.
+	Encoding Encoding
+	// Workers is the number of files ParseFiles and ParseDir parse at the
+	// same time; GOMAXPROCS if not positive.
+	Workers int
+	// DecodeLaTeX replaces LaTeX in field values by Unicode text, as
+	// DecodeLaTeX does, except in verbatim fields such as url and doi.
+	DecodeLaTeX bool
+}
+
+// CaseMode tells Parse how to treat the case of names.
+type CaseMode int8
+
+const (
+	LowerTypes CaseMode = iota // lowercase entry types only
+	LowerAll                   // lowercase entry types and field names
+	KeepCase                   // keep names as written
+)
+
+// DupPolicy tells Parse what to do with repeated fields.
+type DupPolicy int8
+
+const (
+	DupKeepAll       DupPolicy = iota // keep every occurrence
+	DupKeepFirst                      // keep the first occurrence
+	DupKeepLast                       // replace the first occurrence by the last one
+	DupConcat                         // join the values in order
+	DupConcatReverse                  // join the values in reverse order
+)
+
+// joinValues combines the values of a repeated field following policy;
+// DupKeepAll keeps the first value.
+func joinValues(values []string, policy DupPolicy, sep string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	if sep == "" {
+		sep = "; "
+	}
+	switch policy {
+	case DupKeepLast:
+		return values[len(values)-1]
+	case DupConcat:
+		return strings.Join(values, sep)
+	case DupConcatReverse:
+		rev := slices.Clone(values)
+		slices.Reverse(rev)
+		return strings.Join(rev, sep)
+	}
+	return values[0]
 }
 
 // Parse parses a Google scholar bibtex export provided as io.Reader or
-// a name of a file.
+// a name of a file. Problems in the input are returned as ParseErrors
+// along with whatever could be parsed.
 func Parse(r io.Reader, fileName string, opts Options) (*File, error) {
 	if r == nil {
 		if fileName == "" {
@@ -44,137 +129,514 @@ func Parse(r io.Reader, fileName string, opts Options) (*File, error) {
 }
 
 type parser struct {
-	r         *bufio.Reader
-	fileName  string
-	rawBuffer []byte // using for reading
-	lineNum   int
-	// offset is the input stream byte offset of the current reader position.
-	offset int64
+	lx      *lexer
+	file    *File // receives the records and macro definitions
+	opts    Options
+	peeked  *token // token read ahead by peek
+	last    token  // last token read from the lexer
+	key     string // key of the record being parsed
+	errs    ParseErrors
+	stopped bool // set after an error in strict mode
+	resync  bool // set after a problem: the text up to the next @ is dropped
+	start   Pos  // where the node being parsed starts
+	val     Span // the last value read
 }
 
 func newParser(r io.Reader, fileName string, opts Options) *parser {
 	return &parser{
-		r:        bufio.NewReaderSize(r, 2048),
-		fileName: fileName,
+		lx:   newLexer(newDecoder(r, opts.Encoding)),
+		file: newRoot(fileName),
+		opts: opts,
 	}
 }
 
-// readLine reads the next line without the trainling EOF marker(S)
-// If EOF is hit without a trailing endline, it will be omitted.
-// If some bytes were read, then the error is never io.EOF.
-// The result is only valid until the next call to readLine.
-func (p *parser) readLine() ([]byte, error) {
-	line, err := p.r.ReadSlice('\n')
-	if err == bufio.ErrBufferFull {
-		p.rawBuffer = append(p.rawBuffer[:0], line...)
-		for err == bufio.ErrBufferFull {
-			line, err = p.r.ReadSlice('\n')
-			p.rawBuffer = append(p.rawBuffer, line...)
-		}
-		line = p.rawBuffer
+// scan returns the next token.
+func (p *parser) scan() token {
+	if tok := p.peeked; tok != nil {
+		p.peeked = nil
+		return *tok
 	}
-	readSize := len(line)
-	if readSize > 0 && err == io.EOF {
-		err = nil
-		if line[readSize-1] == '\r' {
-			line = line[:readSize-1]
-		}
+	p.last = p.lx.next()
+	return p.last
+}
+
+// peek returns the next token without consuming it.
+func (p *parser) peek() token {
+	if p.peeked == nil {
+		p.last = p.lx.next()
+		tok := p.last
+		p.peeked = &tok
 	}
-	p.lineNum++
-	p.offset += int64(readSize)
-	// Normalize \r\n to \n on all input lines.
-	if n := len(line); n >= 2 && line[n-2] == '\r' && line[n-1] == '\n' {
-		line[n-2] = '\n'
-		line = line[:n-1]
+	return *p.peeked
+}
+
+func (p *parser) errorAt(pos Pos, format string, args ...any) *ParseError {
+	return &ParseError{
+		File:   p.file.name,
+		Line:   pos.Line,
+		Column: pos.Column,
+		Offset: pos.Offset,
+		Key:    p.key,
+		Msg:    fmt.Sprintf(format, args...),
 	}
-	return line, err
 }
 
-// getRune returns the next rune in b or utf8.RuneError.
-// func getRune(b []byte) rune {
-// 	r, _ := utf8.DecodeRune(b)
-// 	return r
-// }
+// errorf reports a problem at tok.
+func (p *parser) errorf(tok token, format string, args ...any) error {
+	return p.errorAt(tok.pos, format, args...)
+}
+
+// warn reports a problem that the parser worked around. It is an error
+// only in strict mode.
+func (p *parser) warn(err error) error {
+	if p.opts.Strict {
+		return err
+	}
+	p.errs = append(p.errs, err.(*ParseError))
+	return nil
+}
 
 func (p *parser) parse() (*File, error) {
-	root := newRoot(p.fileName)
-	var (
-		scanErr error
-		line    []byte
-		currentNode *Record
-	)
-	result := func(msg string) (*File, error) {
-		//TODO: add error msgs
-		return root, fmt.Errorf("parsing error at %d: %s", p.lineNum, msg)
-	}
-	ignored := false 
-mainloop:
-	for scanErr == nil {
-		line, scanErr = p.readLine()
-		// fmt.Printf("%t %t %s \n", ignored, currentNode.IsRoot(), string(line))	
-		if len(line) == 0 { //error or empty line or junk line
-			line = nil
-			continue mainloop
-		}
-		// we have text
-		//TODO: process commands 'comment' and 'premable' read value
-		// 'string' (a macro) read value and store in a map
-		//TODO: allow use of ( instead of {; set righ delimiter to ) or }
+	root := p.file
+	for {
+		n := p.next()
+		if n == nil {
+			break
+		}
+		if rec, ok := n.(*Record); ok {
+			root.AddRecord(rec)
+		} else {
+			root.addNode(n)
+		}
+	}
+	return root, p.err()
+}
+
+// err returns the error, if any, to report at the end of parsing.
+func (p *parser) err() error {
+	if p.lx.err != nil {
+		return fmt.Errorf("can't read %s: %w", p.file.name, p.lx.err)
+	}
+	if len(p.errs) > 0 {
+		return p.errs
+	}
+	return nil
+}
+
+// next returns the next node in the input or nil at the end of input.
+// Problems are recorded in errs; the entry concerned is dropped and, unless
+// in strict mode, parsing resumes at the next @.
+func (p *parser) next() Node {
+	for !p.stopped {
+		n, err := p.entry()
 		switch {
-		case line[0] == AT && currentNode!= nil:
-			return result("invalid @; possibly record missing line starting with }")
-		case line[0] == AT:
-			// we are at root and we have a line starting with @, parse a record header
-			// -> recordtype { citekey, which can be anything till comma or whitespace
-			idx := bytes.IndexByte(line, LBRACE)
-			if idx == -1 {
-				return result("{ is missing")
+		case err != nil:
+			if junk := p.fail(err); junk != nil {
+				return junk
 			}
-			//ignore non-article entries 
-			typ:= strings.ToLower(trimAffixes(line[1:idx],true))
-			if typ == "comment" || typ == "preamble" || typ == "string" {
-				if bytes.IndexByte(line, RBRACE) == -1 {   // not a one liner 
-				ignored = true 
-				}				
-				continue mainloop
-			} 
-			ignored = false 
-			//create a new record and set as current node
-			currentNode = &Record{
-				key:trimAffixes(line[idx+1:], false), 
-				value: typ,                                //record type
-				line:  p.lineNum}
-			// continue mainloop
-		case line[0] == RBRACE && ignored:
-			ignored = false 
-		case line[0] == RBRACE && currentNode == nil:
-			return result("} outside a record")
-		case line[0] == RBRACE:
-			//add node to root and switch to root
-			root.AddRecord(currentNode)
-			currentNode = nil
-		default:
-			if ignored || currentNode == nil { // text directly under root or ignored
-				// line = nil
-				continue mainloop
+		case n != skipped:
+			return n
+		}
+	}
+	return nil
+}
+
+// fail records err and skips the rest of the entry being parsed. In
+// lossless mode, it returns the skipped text as junk; otherwise, the text
+// up to the next @ is dropped as well.
+func (p *parser) fail(err error) Node {
+	if p.lx.overflow {
+		err = p.errorAt(p.lx.pos, "entry is longer than %d bytes", p.opts.MaxRecordSize)
+	}
+	p.errs = append(p.errs, err.(*ParseError))
+	if p.opts.Strict {
+		p.stopped = true
+		return nil
+	}
+	p.peeked = nil
+	if p.last.kind == tokAt && p.last.end == len(p.lx.raw) {
+		// the @ starts the next entry
+		p.lx.unread(1, p.last.pos)
+	}
+	if p.opts.Lossless && len(p.lx.raw) > 0 {
+		return &Junk{text: strings.TrimSpace(string(p.lx.raw)), src: string(p.lx.raw), line: p.start.Line}
+	}
+	p.resync = !p.opts.Lossless
+	return nil
+}
+
+// skipped is returned by entry for a node that the options drop.
+var skipped Node = &Junk{}
+
+// entry reads the next node in the input.
+func (p *parser) entry() (Node, error) {
+	p.lx.resetRaw()
+	p.lx.setLimit(0)
+	p.start = p.lx.pos
+	p.key = ""
+	line := p.lx.pos.Line
+	text, more := p.lx.junk()
+	if p.resync {
+		// the rest of an entry that could not be parsed
+		text, p.resync = "", false
+	}
+	if s := strings.TrimSpace(text); s != "" || (p.opts.Lossless && text != "") {
+		line += strings.Count(text[:strings.Index(text, s)], "\n")
+		return &Junk{text: s, src: p.src(), line: line}, nil
+	}
+	if !more {
+		return nil, nil
+	}
+	p.lx.setLimit(p.opts.MaxRecordSize)
+	at := p.scan()
+	line = at.pos.Line
+	typTok := p.scan()
+	tok := typTok
+	if tok.kind != tokName {
+		return nil, p.errorf(tok, "entry type expected after @, found %s", tok.kind)
+	}
+	typ := strings.ToLower(tok.text)
+	if typ == "comment" {
+		s, err := p.comment()
+		if err != nil {
+			return nil, err
+		}
+		if p.opts.DropComments && !p.opts.Lossless {
+			return skipped, nil
+		}
+		return &Comment{text: s, src: p.src(), line: line}, nil
+	}
+	// entries are delimited by either braces or parentheses
+	tok = p.scan()
+	closing := tokRBrace
+	switch tok.kind {
+	case tokLBrace:
+	case tokLParen:
+		closing = tokRParen
+	default:
+		return nil, p.errorf(tok, "{ or ( is missing")
+	}
+	switch typ {
+	case "preamble":
+		// preambles are often plain LaTeX rather than a value
+		// expression, so their body is read as is
+		s, ok := p.lx.balanced(closers[closing][0], closers[closing][1])
+		if !ok {
+			return nil, p.errorf(at, "preamble is not closed")
+		}
+		return &Preamble{text: strings.TrimSpace(s), src: p.src(), line: line}, nil
+	case "string":
+		fld, err := p.field()
+		if err != nil {
+			return nil, err
+		}
+		p.file.setMacro(fld.key, fld.value)
+		if err := p.expect(closing); err != nil {
+			return nil, err
+		}
+		return &MacroDef{field: fld, src: p.src(), line: line}, nil
+	}
+	if p.opts.Case == KeepCase {
+		typ = typTok.text
+	}
+	rec := &Record{
+		value:    typ,
+		line:     line,
+		parens:   closing == tokRParen,
+		span:     Span{Start: at.pos},
+		typeSpan: Span{typTok.pos, typTok.endPos},
+	}
+	if p.opts.Lossless {
+		rec.syn = &recordSyntax{
+			typ:      typTok.text,
+			typStart: p.index(typTok.pos),
+			typEnd:   p.index(typTok.endPos),
+		}
+	}
+	if err := p.record(rec, closing); err != nil {
+		return nil, err
+	}
+	if !p.allowed(typ) {
+		if !p.opts.Lossless {
+			return skipped, nil
+		}
+		return &Junk{text: strings.TrimSpace(p.src()), src: p.src(), line: line}, nil
+	}
+	return rec, nil
+}
+
+// allowed reports whether records of type typ are to be kept.
+func (p *parser) allowed(typ string) bool {
+	if len(p.opts.Types) == 0 {
+		return true
+	}
+	for _, t := range p.opts.Types {
+		if strings.EqualFold(t, typ) {
+			return true
+		}
+	}
+	return false
+}
+
+// src returns the source of the node just read if it is to be kept.
+func (p *parser) src() string {
+	if !p.opts.Lossless {
+		return ""
+	}
+	return string(p.lx.raw)
+}
+
+func (p *parser) expect(kind tokenKind) error {
+	if tok := p.scan(); tok.kind != kind {
+		return p.errorf(tok, "%s expected, found %s", kind, tok.kind)
+	}
+	return nil
+}
+
+// closers maps a closing delimiter token to its opening and closing bytes.
+var closers = map[tokenKind][2]byte{
+	tokRBrace: {LBRACE, RBRACE},
+	tokRParen: {LPAREN, RPAREN},
+}
+
+// comment reads the body of an @comment, which is either delimited or
+// runs to the end of the line.
+func (p *parser) comment() (string, error) {
+	p.lx.skipSpaces()
+	pos := p.lx.pos
+	c, _ := p.lx.peekByte()
+	if c != LBRACE && c != LPAREN {
+		return strings.TrimSpace(p.lx.restOfLine()), nil
+	}
+	p.lx.readByte()
+	closing := RBRACE
+	if c == LPAREN {
+		closing = RPAREN
+	}
+	s, ok := p.lx.balanced(c, closing)
+	if !ok {
+		return s, p.errorAt(pos, "comment is not closed")
+	}
+	return strings.TrimSpace(s), nil
+}
+
+// record parses a record starting from its citation key up to and
+// including its closing delimiter.
+func (p *parser) record(rec *Record, closing tokenKind) error {
+	p.lx.skipSpaces()
+	keyStart := p.lx.pos
+	rec.key = p.lx.key(closers[closing][1])
+	rec.keySpan = Span{keyStart, p.lx.pos}
+	p.key = rec.key
+	if syn := rec.syn; syn != nil {
+		syn.key = rec.key
+		syn.keyStart, syn.keyEnd = p.index(keyStart), p.index(p.lx.pos)
+	}
+	tok := p.scan()
+	switch tok.kind {
+	case closing:
+		p.endRecord(rec, tok.start)
+		return nil
+	case tokComma:
+	default:
+		return p.errorf(tok, ", expected after key, found %s", tok.kind)
+	}
+	if rec.syn != nil {
+		rec.syn.head = string(p.lx.raw)
+	}
+	start := tok.end // where the source of the next field starts
+	for {
+		tok = p.scan()
+		if tok.kind == closing { // trailing comma before the closing delimiter
+			p.endRecord(rec, start)
+			return nil
+		}
+		if tok.kind != tokName {
+			return p.errorf(tok, "field name expected, found %s", tok.kind)
+		}
+		name := tok
+		fld, salvaged, err := p.fieldValue(name)
+		if err != nil {
+			return err
+		}
+		fld.nameSpan = Span{name.pos, name.endPos}
+		fld.valueSpan = p.val
+		end := p.index(p.val.End)
+		if !salvaged {
+			// the comma, if any, was eaten with a salvaged value
+			tok = p.scan()
+			switch tok.kind {
+			case closing:
+			case tokComma:
+				end = tok.end
+			default:
+				return p.errorf(tok, ", or %s expected after field %s, found %s", closing, fld.key, tok.kind)
 			}
-			// we are in a record so parse } or fields: fldname= value,
-			idx := bytes.IndexByte(line, EQUAL)
-			if idx == -1 {
-				return result("= is missing")
+		}
+		if rec.syn != nil {
+			raw := p.lx.raw
+			fld.syn = &fieldSyntax{
+				pre:  string(raw[start:name.start]),
+				mid:  string(raw[name.end:p.index(p.val.Start)]),
+				src:  string(raw[start:end]),
+				post: string(raw[p.index(p.val.End):end]),
+				orig: fld,
 			}
-			fldname:= trimAffixes(line[:idx], true)
-			value:= trimAffixes(line[idx+1:], false)
-			fld := Field{
-				value: string(value),
-				key:  fldname, 
-				line:  p.lineNum}
-			// fmt.Printf("%s\n", fld.value)
-			currentNode.addField(fld)
-			// comma is normally optional before the record's closing RBRACE,
-			// but here always it is always optional
 		}
-	} //for
-	return root, nil
+		p.addField(rec, fld)
+		start = end
+		if !salvaged && tok.kind == closing {
+			p.endRecord(rec, end)
+			return nil
+		}
+	}
+}
+
+// addField adds fld to rec applying the policy for repeated fields.
+func (p *parser) addField(rec *Record, fld Field) {
+	if p.opts.Duplicates != DupKeepAll {
+		for i := range rec.fields {
+			old := &rec.fields[i]
+			if !strings.EqualFold(old.key, fld.key) {
+				continue
+			}
+			switch p.opts.Duplicates {
+			case DupKeepLast:
+				*old = fld
+			case DupConcat, DupConcatReverse:
+				old.value = joinValues([]string{old.value, fld.value}, p.opts.Duplicates, p.opts.DupSep)
+				old.raw = ""
+			}
+			return
+		}
+	}
+	rec.addField(fld)
 }
 
+// index returns the index in lexer.raw of the byte at pos.
+func (p *parser) index(pos Pos) int {
+	return pos.Offset - p.start.Offset
+}
+
+// endRecord completes a record whose closing delimiter was just read and
+// whose fields end at offset end of lexer.raw.
+func (p *parser) endRecord(rec *Record, end int) {
+	rec.span.End = p.lx.pos
+	syn := rec.syn
+	if syn == nil {
+		return
+	}
+	if syn.head == "" {
+		syn.head = string(p.lx.raw[:end])
+	}
+	syn.tail = string(p.lx.raw[end:])
+	syn.pre, syn.mid = "\n  ", " = "
+	if n := len(rec.fields); n > 0 {
+		last := rec.fields[n-1].syn
+		syn.pre, syn.mid = last.pre, last.mid
+		syn.trailingComma = endsWithComma(last.src)
+	}
+}
+
+// field parses a complete name = value pair.
+func (p *parser) field() (Field, error) {
+	tok := p.scan()
+	if tok.kind != tokName {
+		return Field{}, p.errorf(tok, "name expected, found %s", tok.kind)
+	}
+	fld, _, err := p.fieldValue(tok)
+	return fld, err
+}
+
+// fieldValue parses the = value part of a field whose name is in tok.
+// salvaged is true if the value was a quoted string missing its closing
+// quote, which was recovered from the first line of the string.
+func (p *parser) fieldValue(name token) (fld Field, salvaged bool, err error) {
+	fld = Field{
+		key:  name.text,
+		line: name.pos.Line,
+	}
+	if p.opts.Case == LowerAll {
+		fld.key = strings.ToLower(fld.key)
+	}
+	if err = p.expect(tokEqual); err != nil {
+		return fld, false, err
+	}
+	parts, salvaged, err := p.value()
+	if err != nil {
+		return fld, false, err
+	}
+	if len(parts) == 1 && !parts[0].isMacro() {
+		fld.value, fld.delim = parts[0].text, parts[0].delim
+		if !salvaged {
+			fld.value = p.clean(fld.value)
+		}
+		p.decode(&fld)
+		return fld, salvaged, nil
+	}
+	fld.value = p.clean(p.file.expand(parts))
+	if p.opts.KeepMacros {
+		fld.raw = joinParts(parts)
+	}
+	p.decode(&fld)
+	return fld, false, nil
+}
+
+// decode decodes the LaTeX in the value of fld if asked to.
+func (p *parser) decode(fld *Field) {
+	if p.opts.DecodeLaTeX && !isVerbatim(fld.key) {
+		fld.value = DecodeLaTeX(fld.value)
+	}
+}
+
+// clean trims s and, if asked to, normalises its white space.
+func (p *parser) clean(s string) string {
+	if p.opts.NormalizeSpace {
+		return strings.Join(strings.Fields(s), " ")
+	}
+	return strings.TrimSpace(s)
+}
+
+// value parses a value expression: one or more operands joined by #. An
+// operand is either delimited by balanced braces or quotes, and may then
+// span several lines, or is a bare number or macro name.
+func (p *parser) value() (parts []valuePart, salvaged bool, err error) {
+	for {
+		tok := p.scan()
+		if parts == nil {
+			p.val.Start = tok.pos
+		}
+		switch tok.kind {
+		case tokLBrace:
+			s, ok := p.lx.braced()
+			if !ok {
+				return parts, false, p.errorf(tok, "value is not closed")
+			}
+			parts = append(parts, valuePart{s, DelimBrace})
+		case tokQuote:
+			s, ok := p.lx.quoted()
+			if !ok {
+				err := p.errorf(tok, "string is not closed")
+				if len(parts) > 0 {
+					return parts, false, err
+				}
+				if err := p.warn(err); err != nil {
+					return parts, false, err
+				}
+				// the comma, if any, is still attached to the line
+				p.val.End = p.lx.pos
+				return []valuePart{{trimAffixes([]byte(s), false), DelimQuote}}, true, nil
+			}
+			parts = append(parts, valuePart{s, DelimQuote})
+		case tokName:
+			parts = append(parts, valuePart{tok.text, DelimNone})
+		default:
+			return parts, false, p.errorf(tok, "value expected, found %s", tok.kind)
+		}
+		p.val.End = p.lx.pos
+		if p.peek().kind != tokHash {
+			return parts, false, nil
+		}
+		p.scan()
+	}
+}
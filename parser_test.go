@@ -1,12 +1,17 @@
 package bibsin
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"testing"
+	"testing/iotest"
 
 	"github.com/drgo/core/tu"
 )
@@ -91,9 +96,20 @@ const bib2 = `@article{FuMetalhalideperovskite2019,
 }
 `
 
+// parseBib1 parses bib1, whose only problem is a missing closing quote.
+func parseBib1(t *testing.T, opts Options) *File {
+	t.Helper()
+	f, err := Parse(strings.NewReader(bib1), "bib1", opts)
+	var perrs ParseErrors
+	tu.Equal(t, errors.As(err, &perrs), true, tu.FailNow)
+	tu.Equal(t, len(perrs), 1, tu.FailNow)
+	tu.Equal(t, *perrs[0], ParseError{File: "bib1", Line: 34, Column: 13, Offset: 1077,
+		Key: "SunEnablingSiliconSolar2014", Msg: "string is not closed"})
+	return f
+}
+
 func TestParser(t *testing.T) {
-	f, err := Parse(strings.NewReader(bib1), "bib1", Options{})
-	tu.Equal(t, err, nil, tu.FailNow)
+	f := parseBib1(t, Options{})
 	tu.NotNil(t, f, tu.FailNow)
 	Print(os.Stdout, f)
 	tu.Equal(t, len(f.Records), 3, tu.FailNow)
@@ -108,6 +124,1148 @@ func TestParser(t *testing.T) {
 	// tu.Equal(t, c.Field("pages"), "16151", tu.FailNow)
 }
 
+func TestParseLayout(t *testing.T) {
+	const src = `@article{one, title = {One line}, year = {2019}}
+@book{two,
+  title = {Two} , year = {2020} ,
+  author = {A. Author}}
+@misc{three}@misc{four,note={a
+b}
+,}`
+	f, err := Parse(strings.NewReader(src), "layout", Options{})
+	tu.Equal(t, err, nil, tu.FailNow)
+	tu.Equal(t, f.RecordCount(), 4, tu.FailNow)
+	tu.Equal(t, f.Records[0].Field("year"), "2019")
+	tu.Equal(t, f.Records[1].Field("author"), "A. Author")
+	tu.Equal(t, f.Records[1].fields[2].Line(), 4)
+	tu.Equal(t, f.Records[2].Key(), "three")
+	tu.Equal(t, len(f.Records[2].fields), 0)
+	tu.Equal(t, f.Records[3].Line(), 5)
+	tu.Equal(t, f.Records[3].Field("note"), "a\nb")
+
+	_, err = Parse(strings.NewReader("@article{x, title {y}}"), "bad", Options{})
+	tu.NotNil(t, err)
+}
+
+func TestParseValues(t *testing.T) {
+	const src = `@article{key,
+  abstract = {First line
+    second {line} with {nested {braces}}},
+  title = "A {"}quoted{"} title with {Braces}",
+  year = 2019,
+  publisher = {Springer Science and Business Media {LLC}}
+}`
+	f, err := Parse(strings.NewReader(src), "values", Options{})
+	tu.Equal(t, err, nil, tu.FailNow)
+	tu.Equal(t, f.RecordCount(), 1, tu.FailNow)
+	rec := f.Records[0]
+	tu.Equal(t, rec.Field("abstract"), "First line\n    second {line} with {nested {braces}}")
+	tu.Equal(t, rec.Field("title"), `A {"}quoted{"} title with {Braces}`)
+	tu.Equal(t, rec.Field("year"), "2019")
+	tu.Equal(t, rec.Field("publisher"), "Springer Science and Business Media {LLC}")
+	delims := []Delim{DelimBrace, DelimQuote, DelimNone, DelimBrace}
+	for i, fld := range rec.fields {
+		tu.Equal(t, fld.Delim(), delims[i])
+	}
+	tu.Equal(t, rec.fields[1].BibtexRepr(), `title="A {"}quoted{"} title with {Braces}"`)
+	tu.Equal(t, rec.fields[2].BibtexRepr(), `year=2019`)
+}
+
+func TestParseMacros(t *testing.T) {
+	const src = `@string{goossens = "Goossens, Michel"}
+@STRING{mittelbach = {Mittelbach, Franck}}
+@string{both = goossens # " and " # mittelbach}
+@book{companion,
+  author = goossens # " and " # mittelbach,
+  editor = Both,
+  month = feb,
+  year = 2004,
+  note = undefined
+}`
+	f, err := Parse(strings.NewReader(src), "macros", Options{})
+	tu.Equal(t, err, nil, tu.FailNow)
+	tu.Equal(t, f.RecordCount(), 1, tu.FailNow)
+	tu.Equal(t, f.MacroNames(), []string{"goossens", "mittelbach", "both"})
+	rec := f.Records[0]
+	tu.Equal(t, rec.Field("author"), "Goossens, Michel and Mittelbach, Franck")
+	tu.Equal(t, rec.Field("editor"), rec.Field("author"))
+	tu.Equal(t, rec.Field("month"), "February")
+	tu.Equal(t, rec.Field("year"), "2004")
+	tu.Equal(t, rec.Field("note"), "undefined")
+	tu.Equal(t, rec.fields[0].Raw(), "")
+
+	f, err = Parse(strings.NewReader(src), "macros", Options{KeepMacros: true})
+	tu.Equal(t, err, nil, tu.FailNow)
+	rec = f.Records[0]
+	tu.Equal(t, rec.Field("author"), "Goossens, Michel and Mittelbach, Franck")
+	tu.Equal(t, rec.fields[0].BibtexRepr(), `author=goossens # " and " # mittelbach`)
+	tu.Equal(t, rec.fields[2].BibtexRepr(), `month=feb`)
+	tu.Equal(t, rec.fields[3].BibtexRepr(), `year=2004`)
+	var b strings.Builder
+	tu.Equal(t, Print(&b, f), nil)
+	f2, err := Parse(strings.NewReader(b.String()), "printed", Options{})
+	tu.Equal(t, err, nil, tu.FailNow)
+	tu.Equal(t, f2.Records[0].Field("author"), rec.Field("author"))
+	tu.Equal(t, f2.Records[0].Field("month"), "February")
+}
+
+func TestParseParens(t *testing.T) {
+	const src = `@string(acs = "American Chemical Society ({ACS})")
+@comment(a (nested) comment)
+@article(SunEnablingSiliconSolar2014,
+  publisher = acs,
+  title = {Enabling (silicon) solar},
+  year = 2014)
+@book{braced, title = {Braced}}`
+	f, err := Parse(strings.NewReader(src), "parens", Options{})
+	tu.Equal(t, err, nil, tu.FailNow)
+	tu.Equal(t, f.RecordCount(), 2, tu.FailNow)
+	rec := f.Records[0]
+	tu.Equal(t, rec.Key(), "SunEnablingSiliconSolar2014")
+	tu.Equal(t, rec.Parens(), true)
+	tu.Equal(t, rec.Field("publisher"), "American Chemical Society ({ACS})")
+	tu.Equal(t, rec.Field("title"), "Enabling (silicon) solar")
+	tu.Equal(t, f.Records[1].Parens(), false)
+
+	var b strings.Builder
+	PrintWith(&b, rec, PrintOptions{Parens: true})
+	tu.Equal(t, strings.HasPrefix(b.String(), "\n@article(SunEnablingSiliconSolar2014,\n"), true)
+	tu.Equal(t, strings.HasSuffix(b.String(), ")\n"), true)
+	b.Reset()
+	Print(&b, rec)
+	tu.Equal(t, strings.HasSuffix(b.String(), "}\n"), true)
+
+	_, err = Parse(strings.NewReader("@article(key, title = {x}}"), "mismatch", Options{})
+	tu.NotNil(t, err)
+}
+
+func TestParseNodes(t *testing.T) {
+	f := parseBib1(t, Options{})
+	nodes := f.Nodes()
+	tu.Equal(t, len(nodes), 9, tu.FailNow)
+	kinds := make([]string, len(nodes))
+	for i, n := range nodes {
+		kinds[i] = fmt.Sprintf("%T", n)
+	}
+	tu.Equal(t, kinds, []string{"*bibsin.MacroDef", "*bibsin.Junk", "*bibsin.Record",
+		"*bibsin.Comment", "*bibsin.Preamble", "*bibsin.Record", "*bibsin.MacroDef",
+		"*bibsin.Record", "*bibsin.Comment"})
+	tu.Equal(t, nodes[0].(*MacroDef).Name(), "goossens")
+	tu.Equal(t, nodes[0].(*MacroDef).Value(), "Goossens, Michel")
+	tu.Equal(t, nodes[1].(*Junk).Text(), "This line is an implicit comment.")
+	tu.Equal(t, nodes[1].Line(), 3)
+	tu.Equal(t, nodes[3].(*Comment).Text(), "This is a comment.\n    Spanning over two lines.")
+	tu.Equal(t, nodes[4].(*Preamble).Text(), "e = mc^2")
+	tu.Equal(t, nodes[8].(*Comment).Text(), "This is another comment")
+
+	// other nodes survive a FixKeys and Print cycle and stay in place
+	// when records are reordered
+	_, err := FixKeys(f, nil, true)
+	tu.Equal(t, err, nil)
+	f.Records[0], f.Records[2] = f.Records[2], f.Records[0]
+	var b strings.Builder
+	tu.Equal(t, Print(&b, f), nil)
+	f2, err := Parse(strings.NewReader(b.String()), "printed", Options{})
+	tu.Equal(t, err, nil, tu.FailNow)
+	nodes2 := f2.Nodes()
+	tu.Equal(t, len(nodes2), len(nodes), tu.FailNow)
+	for i, n := range nodes2 {
+		tu.Equal(t, fmt.Sprintf("%T", n), kinds[i])
+	}
+	tu.Equal(t, f2.Records[0].Key(), f.Records[0].Key())
+	tu.Equal(t, nodes2[1].(*Junk).Text(), "This line is an implicit comment.")
+
+	n := parseTestFile(t, "tests/salah.bib")
+	tu.Equal(t, n.Nodes()[0].(*Junk).Text(), "%Presentations")
+}
+
+func TestLossless(t *testing.T) {
+	srcs := map[string]string{"bib2": bib2}
+	for _, name := range []string{"scholar.bib", "salah.bib", "biblatex-examples.bib"} {
+		b, err := os.ReadFile(filepath.Join("tests", name))
+		tu.Equal(t, err, nil, tu.FailNow)
+		srcs[name] = string(b)
+	}
+	srcs["crlf"] = "%junk\r\n@article{x,\r\n  title = {A\r\n  B} ,\r\n  year=2000,}\r\n"
+	for name, src := range srcs {
+		f, err := Parse(strings.NewReader(src), name, Options{Lossless: true, KeepMacros: true})
+		tu.Equal(t, err, nil, tu.FailNow)
+		var b strings.Builder
+		tu.Equal(t, Print(&b, f), nil)
+		tu.Equal(t, b.String(), src)
+	}
+	var b strings.Builder
+	tu.Equal(t, Print(&b, parseBib1(t, Options{Lossless: true})), nil)
+	tu.Equal(t, b.String(), bib1)
+
+	const src = `% header
+@Article{old,
+    author = {A. Author},
+    title  = {Old title},
+    year   = 2001
+}
+
+@book{other, title={Untouched}}
+`
+	f, err := Parse(strings.NewReader(src), "edit", Options{Lossless: true})
+	tu.Equal(t, err, nil, tu.FailNow)
+	rec := f.Records[0]
+	rec.key = "new"
+	rec.fields[1].value = "New title"
+	rec.fields = rec.fields[:2]
+	rec.addField(Field{key: "note", value: "added"})
+	b.Reset()
+	tu.Equal(t, Print(&b, f), nil)
+	tu.Equal(t, b.String(), `% header
+@Article{new,
+    author = {A. Author},
+    title  = {New title},
+    note   = {added}
+}
+
+@book{other, title={Untouched}}
+`)
+}
+
+func TestParseErrors(t *testing.T) {
+	const src = `@article{good1, title = {One}}
+@article{bad1, title {Two}}
+@article{good2, title = {Three}}
+@article{bad2, title = {Four}
+@book{good3, title = "Five"}
+@article{bad3, title = {never closed
+`
+	f, err := Parse(strings.NewReader(src), "errs.bib", Options{})
+	var perrs ParseErrors
+	tu.Equal(t, errors.As(err, &perrs), true, tu.FailNow)
+	tu.Equal(t, len(perrs), 3, tu.FailNow)
+	tu.Equal(t, *perrs[0], ParseError{File: "errs.bib", Line: 2, Column: 22, Offset: 52, Key: "bad1",
+		Msg: "= expected, found {"})
+	tu.Equal(t, *perrs[1], ParseError{File: "errs.bib", Line: 5, Column: 1, Offset: 122, Key: "bad2",
+		Msg: ", or } expected after field title, found @"})
+	tu.Equal(t, perrs[2].Key, "bad3")
+	tu.Equal(t, perrs[2].Msg, "value is not closed")
+	tu.Equal(t, err.Error(), "errs.bib:2:22: = expected, found { (record bad1) (and 2 more errors)")
+	keys := []string{}
+	for _, rec := range f.Records {
+		keys = append(keys, rec.Key())
+	}
+	tu.Equal(t, keys, []string{"good1", "good2", "good3"})
+	// the rest of a skipped entry is not kept as junk
+	tu.Equal(t, len(f.Nodes()), 3)
+	var out strings.Builder
+	tu.Equal(t, Print(&out, f), nil)
+	tu.Equal(t, strings.Contains(out.String(), "Two"), false)
+
+	f, err = Parse(strings.NewReader(src), "errs.bib", Options{Strict: true})
+	tu.Equal(t, errors.As(err, &perrs), true, tu.FailNow)
+	tu.Equal(t, len(perrs), 1)
+	tu.Equal(t, f.RecordCount(), 1)
+
+	// the skipped text is kept as junk in lossless mode
+	f, _ = Parse(strings.NewReader(src), "errs.bib", Options{Lossless: true})
+	var b strings.Builder
+	tu.Equal(t, Print(&b, f), nil)
+	tu.Equal(t, b.String(), src)
+}
+
+func TestParseSpans(t *testing.T) {
+	const src = "% é\n@Article{clé,\n  title = {Déjà vu},\n  year = 1999 # \"a\"\n}\n"
+	f, err := Parse(strings.NewReader(src), "spans", Options{})
+	tu.Equal(t, err, nil, tu.FailNow)
+	rec := f.Records[0]
+	text := func(sp Span) string { return src[sp.Start.Offset:sp.End.Offset] }
+	tu.Equal(t, rec.Span().Start, Pos{Line: 2, Column: 1, Offset: 5})
+	tu.Equal(t, rec.Span().End, Pos{Line: 5, Column: 2, Offset: len(src) - 1})
+	tu.Equal(t, text(rec.TypeSpan()), "Article")
+	tu.Equal(t, text(rec.KeySpan()), "clé")
+	tu.Equal(t, rec.KeySpan().End.Column, 13)
+	title := rec.fields[0]
+	tu.Equal(t, text(title.NameSpan()), "title")
+	tu.Equal(t, title.NameSpan().Start, Pos{Line: 3, Column: 3, Offset: 22})
+	tu.Equal(t, text(title.ValueSpan()), "{Déjà vu}")
+	tu.Equal(t, title.ValueSpan().End.Column, 20)
+	tu.Equal(t, text(rec.fields[1].ValueSpan()), `1999 # "a"`)
+}
+
+func TestParseOptions(t *testing.T) {
+	const src = `@comment{exported}
+@Article{a,
+  Title = {A  title
+     on two lines},
+  Author = {One},
+  author = {Two}
+}
+@misc{b, note = {skipped}}
+@book{c, title = {` + "0123456789012345678901234567890123456789" + `}}
+`
+	parse := func(opts Options) (*File, error) {
+		return Parse(strings.NewReader(src), "opts", opts)
+	}
+	f, err := parse(Options{})
+	tu.Equal(t, err, nil, tu.FailNow)
+	tu.Equal(t, len(f.Nodes()), 4)
+	rec := f.Records[0]
+	tu.Equal(t, rec.Value(), "article")
+	tu.Equal(t, rec.Field("Title"), "A  title\n     on two lines")
+	tu.Equal(t, len(rec.fields), 3)
+
+	f, err = parse(Options{Case: LowerAll, NormalizeSpace: true, Duplicates: DupConcat})
+	tu.Equal(t, err, nil, tu.FailNow)
+	rec = f.Records[0]
+	tu.Equal(t, rec.Field("title"), "A title on two lines")
+	tu.Equal(t, rec.Field("author"), "One; Two")
+	tu.Equal(t, len(rec.fields), 2)
+
+	f, _ = parse(Options{Case: KeepCase, Duplicates: DupKeepFirst})
+	tu.Equal(t, f.Records[0].Value(), "Article")
+	tu.Equal(t, f.Records[0].Field("Author"), "One")
+	tu.Equal(t, len(f.Records[0].fields), 2)
+	f, _ = parse(Options{Duplicates: DupKeepLast})
+	tu.Equal(t, f.Records[0].Field("author"), "Two")
+	tu.Equal(t, len(f.Records[0].fields), 2)
+
+	f, err = parse(Options{Types: []string{"ARTICLE", "book"}, DropComments: true})
+	tu.Equal(t, err, nil, tu.FailNow)
+	tu.Equal(t, len(f.Nodes()), 2)
+	tu.Equal(t, f.Records[1].Key(), "c")
+
+	f, err = parse(Options{MaxRecordSize: 50})
+	var perrs ParseErrors
+	tu.Equal(t, errors.As(err, &perrs), true, tu.FailNow)
+	tu.Equal(t, len(perrs), 2, tu.FailNow)
+	tu.Equal(t, perrs[0].Key, "a")
+	tu.Equal(t, perrs[0].Msg, "entry is longer than 50 bytes")
+	tu.Equal(t, perrs[1].Key, "c")
+	tu.Equal(t, f.RecordCount(), 1)
+	tu.Equal(t, f.Records[0].Key(), "b")
+
+	// options that drop entries leave the text in place in lossless mode
+	f, _ = parse(Options{Lossless: true, Types: []string{"book"}, DropComments: true, MaxRecordSize: 40})
+	var b strings.Builder
+	tu.Equal(t, Print(&b, f), nil)
+	tu.Equal(t, b.String(), src)
+}
+
+func TestParseEncoding(t *testing.T) {
+	utf16 := func(s string, bigEndian bool) string {
+		var b []byte
+		for _, u := range []rune(s) {
+			if bigEndian {
+				b = append(b, byte(u>>8), byte(u))
+			} else {
+				b = append(b, byte(u), byte(u>>8))
+			}
+		}
+		return string(b)
+	}
+	const src = "@book{k, author = {Müller, José}, note = {“q”}}"
+	tests := []struct {
+		name string
+		in   string
+		enc  Encoding
+		want string
+	}{
+		{"utf-8", src, EncodingAuto, "“q”"},
+		{"utf-8 bom", "\xEF\xBB\xBF" + src, EncodingAuto, "“q”"},
+		{"windows-1252", "@book{k, author = {M\xFCller, Jos\xE9}, note = {\x93q\x94}}", EncodingAuto, "“q”"},
+		{"latin1", "@book{k, author = {M\xFCller, Jos\xE9}, note = {\x93q\x94}}", Latin1, "\u0093q\u0094"},
+		{"invalid utf-8", "@book{k, author = {M\xFCller, Jos\xE9}, note = {q}}", UTF8, "q"},
+		{"utf-16le bom", "\xFF\xFE" + utf16(src, false), EncodingAuto, "“q”"},
+		{"utf-16be bom", "\xFE\xFF" + utf16(src, true), Latin1, "“q”"},
+		{"utf-16be", utf16(src, true), UTF16BE, "“q”"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f, err := Parse(strings.NewReader(test.in), test.name, Options{Encoding: test.enc})
+			tu.Equal(t, err, nil, tu.FailNow)
+			tu.Equal(t, f.RecordCount(), 1, tu.FailNow)
+			rec := f.Records[0]
+			if test.enc == UTF8 {
+				tu.Equal(t, rec.Field("author"), "M\uFFFDller, Jos\uFFFD")
+			} else {
+				tu.Equal(t, rec.Field("author"), "Müller, José")
+			}
+			tu.Equal(t, rec.Field("note"), test.want)
+		})
+	}
+}
+
+func TestScanner(t *testing.T) {
+	const src = `@string{t = "Three"}
+@article{good1, title = {One}}
+@article{bad1, title {Two}}
+@article{good2, title = t}
+`
+	sc := NewScanner(strings.NewReader(src), "scan.bib", Options{})
+	keys := []string{}
+	for sc.Scan() {
+		keys = append(keys, sc.Record().Key())
+	}
+	tu.Equal(t, keys, []string{"good1", "good2"})
+	tu.Equal(t, len(sc.Errors()), 1, tu.FailNow)
+	tu.Equal(t, sc.Errors()[0].Key, "bad1")
+	tu.Equal(t, sc.Err().Error(), "scan.bib:3:22: = expected, found { (record bad1)")
+	tu.Equal(t, sc.Record(), (*Record)(nil))
+
+	sc = NewScanner(strings.NewReader(src), "scan.bib", Options{Strict: true})
+	tu.Equal(t, sc.Scan(), true)
+	tu.Equal(t, sc.Record().Span().Start.Line, 2)
+	tu.Equal(t, sc.Scan(), false)
+	tu.Equal(t, len(sc.Errors()), 1)
+
+	errRead := errors.New("disk on fire")
+	sc = NewScanner(io.MultiReader(strings.NewReader(src), iotest.ErrReader(errRead)), "scan.bib", Options{})
+	for sc.Scan() {
+		v, _ := sc.Macro("t")
+		tu.Equal(t, v, "Three")
+	}
+	tu.Equal(t, errors.Is(sc.Err(), errRead), true)
+
+	f := parseTestFile(t, "./tests/scholar.bib")
+	in, err := os.Open("./tests/scholar.bib")
+	tu.Equal(t, err, nil, tu.FailNow)
+	defer in.Close()
+	sc = NewScanner(in, "scholar.bib", Options{})
+	n := 0
+	for ; sc.Scan(); n++ {
+		tu.Equal(t, sc.Record().Key(), f.Records[n].Key())
+	}
+	tu.Equal(t, sc.Err(), nil)
+	tu.Equal(t, n, f.RecordCount())
+}
+
+func TestParseFiles(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, src string) {
+		path := filepath.Join(dir, name)
+		tu.Equal(t, os.MkdirAll(filepath.Dir(path), 0o755), nil, tu.FailNow)
+		tu.Equal(t, os.WriteFile(path, []byte(src), 0o644), nil, tu.FailNow)
+	}
+	write("b.bib", "@book{b1, title = {B}}\n@book{b2, title {B}}")
+	write("a.bib", "@article{a1, title = {A}}")
+	write("notes.txt", "@misc{txt, title = {T}}")
+	write("sub/c.BIB", "@misc{c1, title = {C}}")
+	names := func(files []*File) []string {
+		var res []string
+		for _, f := range files {
+			res = append(res, filepath.Base(f.Name()))
+		}
+		return res
+	}
+	files, err := ParseDir(dir, false, Options{Workers: 2})
+	tu.Equal(t, names(files), []string{"a.bib", "b.bib"})
+	var el LoadErrors
+	tu.Equal(t, errors.As(err, &el), true, tu.FailNow)
+	tu.Equal(t, len(el), 1)
+	var perrs ParseErrors
+	tu.Equal(t, errors.As(err, &perrs), true, tu.FailNow)
+	tu.Equal(t, perrs[0].Key, "b2")
+	tu.Equal(t, files[1].RecordCount(), 1)
+
+	files, err = ParseDir(dir, true, Options{})
+	tu.Equal(t, names(files), []string{"a.bib", "b.bib", "c.BIB"})
+
+	files, err = ParseFiles([]string{filepath.Join(dir, "sub"), filepath.Join(dir, "*.bib"),
+		filepath.Join(dir, "a.bib"), filepath.Join(dir, "*.none")}, Options{Workers: 1})
+	tu.Equal(t, names(files), []string{"c.BIB", "a.bib", "b.bib"})
+	tu.Equal(t, errors.As(err, &el), true, tu.FailNow)
+	tu.Equal(t, len(el), 2)
+	tu.Equal(t, strings.HasPrefix(el[0].Error(), "no files match"), true)
+
+	tu.Equal(t, strings.HasSuffix(err.Error(), "(and 1 more errors)"), true)
+
+	_, dr, err := Deduplicate(files, nil, SetNoAction)
+	tu.Equal(t, err, nil)
+	tu.Equal(t, dr.DuplicateSetCount, 0)
+
+	// empty files, even first, are fine as long as there are records
+	write("empty/0.bib", "% no records\n")
+	write("empty/1.bib", "@misc{e1, title = {E}}\n@misc{e1, title = {E}}")
+	files, _ = ParseDir(filepath.Join(dir, "empty"), false, Options{})
+	tu.Equal(t, names(files), []string{"0.bib", "1.bib"})
+	_, dr, err = Deduplicate(files, nil, SetNoAction)
+	tu.Equal(t, err, nil)
+	tu.Equal(t, dr.DuplicateSetCount, 1)
+	_, _, err = Deduplicate(files[:1], nil, SetNoAction)
+	tu.Equal(t, err, errors.New("nothing to deduplicate"))
+	_, _, err = Deduplicate(nil, nil, SetNoAction)
+	tu.Equal(t, err, errors.New("nothing to deduplicate"))
+}
+
+func TestResolve(t *testing.T) {
+	const src = `@mvbook{mv, title = {Collected Works}, author = {Knuth, Donald}, year = 2000}
+@book{vol, crossref = {MV}, title = {Volume One}, volume = 1, xdata = {pub}}
+@inbook{ch, crossref = {vol}, title = {Chapter}, pages = {1--10}}
+@xdata{pub, publisher = {Addison-Wesley}, location = {Reading}}
+@inproceedings{paper, crossref = {proc}, xref = {gone}}
+@proceedings{proc, title = {Proceedings}, crossref = {paper}}
+@article{orphan, crossref = {missing}}
+`
+	f, err := Parse(strings.NewReader(src), "xref.bib", Options{})
+	tu.Equal(t, err, nil, tu.FailNow)
+	err = f.Resolve(InheritBibLaTeX)
+	var perrs ParseErrors
+	tu.Equal(t, errors.As(err, &perrs), true, tu.FailNow)
+	msgs := []string{}
+	for _, e := range perrs {
+		msgs = append(msgs, e.Key+": "+e.Msg)
+	}
+	tu.Equal(t, msgs, []string{
+		"proc: crossref entry paper forms a cycle",
+		"paper: xref entry gone not found",
+		"orphan: crossref entry missing not found",
+	})
+	tu.Equal(t, perrs[2].Column, 29)
+	vol, ch := f.Records[1], f.Records[2]
+	tu.Equal(t, vol.Field("maintitle"), "")
+	tu.Equal(t, vol.ResolvedField("maintitle"), "Collected Works")
+	tu.Equal(t, vol.ResolvedField("title"), "Volume One")
+	tu.Equal(t, vol.ResolvedField("publisher"), "Addison-Wesley")
+	tu.Equal(t, ch.ResolvedField("booktitle"), "Volume One")
+	tu.Equal(t, ch.ResolvedField("maintitle"), "Collected Works")
+	tu.Equal(t, ch.ResolvedField("bookauthor"), "Knuth, Donald")
+	tu.Equal(t, ch.ResolvedField("author"), "Knuth, Donald")
+	tu.Equal(t, ch.ResolvedField("location"), "Reading")
+	tu.Equal(t, ch.ResolvedField("volume"), "1")
+	tu.Equal(t, ch.ResolvedField("crossref"), "vol")
+	tu.Equal(t, ch.ResolvedField("xdata"), "")
+	tu.Equal(t, f.Records[4].ResolvedField("booktitle"), "Proceedings")
+
+	f.Resolve(InheritBibTeX)
+	tu.Equal(t, ch.ResolvedField("booktitle"), "")
+	tu.Equal(t, ch.ResolvedField("title"), "Chapter")
+	tu.Equal(t, ch.ResolvedField("publisher"), "Addison-Wesley")
+	tu.Equal(t, ch.ResolvedField("maintitle"), "")
+
+	var b strings.Builder
+	PrintWith(&b, ch, PrintOptions{Inherit: true})
+	tu.Equal(t, strings.Contains(b.String(), "publisher={Addison-Wesley}"), true)
+	f.Resolve(InheritBibLaTeX)
+	chapters := newRoot("chapters")
+	chapters.AddRecord(ch)
+	b.Reset()
+	tu.Equal(t, AsTyp(&b, chapters, "Chapters"), nil)
+	tu.Equal(t, strings.Contains(b.String(), "in Volume One"), false)
+	b.Reset()
+	tu.Equal(t, AsTypWith(&b, chapters, "Chapters", ExportOptions{Inherit: true}), nil)
+	tu.Equal(t, strings.Contains(b.String(), "[Knuth, Donald._Chapter_* (2000)* in Volume One.Addison-Wesley."), true)
+
+	f, _ = Parse(strings.NewReader(src), "xref.bib", Options{Lossless: true})
+	f.Resolve(InheritBibLaTeX)
+	b.Reset()
+	PrintWith(&b, f.Records[2], PrintOptions{Inherit: true})
+	tu.Equal(t, strings.HasPrefix(b.String(), "@inbook{ch, crossref = {vol}, title = {Chapter}, pages = {1--10}, booktitle = {Volume One},"), true)
+	tu.Equal(t, strings.HasSuffix(b.String(), ", bookauthor = {Knuth, Donald}, year = 2000}"), true)
+}
+
+func TestRecordEdit(t *testing.T) {
+	const src = `@Article{old,
+  Title = {Wrong},
+  abstract = {Long},
+  year = 2001,
+  ABSTRACT = {Again}
+}
+`
+	f, err := Parse(strings.NewReader(src), "edit", Options{Lossless: true})
+	tu.Equal(t, err, nil, tu.FailNow)
+	rec := f.Records[0]
+	tu.Equal(t, rec.Field("title"), "Wrong")
+	tu.Equal(t, rec.HasField("TITLE"), true)
+	tu.Equal(t, rec.HasField("journal"), false)
+	rec.SetField("title", "Right")
+	rec.SetField("year", "in press")
+	rec.SetField("journal", "Nature")
+	tu.Equal(t, rec.DeleteField("abstract"), true)
+	tu.Equal(t, rec.DeleteField("abstract"), false)
+	tu.Equal(t, rec.RenameField("Year", "date"), true)
+	rec.ReorderFields("journal", "date")
+	rec.SetKey("new")
+	rec.SetType("report")
+	names := []string{}
+	for _, fld := range rec.Fields() {
+		names = append(names, fld.Key())
+	}
+	tu.Equal(t, names, []string{"journal", "date", "Title"})
+	var b strings.Builder
+	tu.Equal(t, Print(&b, f), nil)
+	tu.Equal(t, b.String(), `@report{new,
+  journal = {Nature},
+  date = {in press},
+  Title = {Right}
+}
+`)
+
+	for _, src := range []string{"@misc{k}", "@misc{k,}", "@misc{k }"} {
+		f, err = Parse(strings.NewReader(src), "empty", Options{Lossless: true})
+		tu.Equal(t, err, nil, tu.FailNow)
+		b.Reset()
+		tu.Equal(t, Print(&b, f), nil)
+		tu.Equal(t, b.String(), src)
+		f.Records[0].SetField("title", "T")
+		b.Reset()
+		tu.Equal(t, Print(&b, f), nil)
+		g, err := Parse(strings.NewReader(b.String()), "edited", Options{Lossless: true})
+		tu.Equal(t, err, nil, tu.FailNow)
+		tu.Equal(t, g.Records[0].Field("title"), "T")
+	}
+	tu.Equal(t, b.String(), "@misc{k, \n  title = {T}}")
+
+	rec = NewRecord("misc", "fresh")
+	rec.SetField("note", "n")
+	b.Reset()
+	tu.Equal(t, Print(&b, rec), nil)
+	tu.Equal(t, b.String(), "\n@misc{fresh,\nnote={n},\n}\n")
+}
+
+func TestRepeatedFields(t *testing.T) {
+	f := parseTestFile(t, "./tests/salah.bib")
+	rec := f.Records[1]
+	tu.Equal(t, rec.Key(), "YoungXuY2018", tu.FailNow)
+	tu.Equal(t, rec.Repeated(), []string{"address"})
+	tu.Equal(t, rec.FieldValues("address"), []string{"Spain", "Madrid"})
+	tu.Equal(t, rec.Field("address"), "Spain")
+	tu.Equal(t, rec.MergedField("address", DupKeepLast, ""), "Madrid")
+	tu.Equal(t, rec.MergedField("address", DupConcat, ""), "Spain; Madrid")
+	tu.Equal(t, rec.MergedField("address", DupConcatReverse, ", "), "Madrid, Spain")
+	tu.Equal(t, rec.MergedField("year", DupConcatReverse, ", "), "2018")
+
+	pres := newRoot("presentations")
+	rec.SetType("presentation")
+	pres.AddRecord(rec)
+	var b strings.Builder
+	tu.Equal(t, AsTyp(&b, pres, "Presentations"), nil)
+	tu.Equal(t, strings.Contains(b.String(), "ECCMID.Spain."), true)
+	b.Reset()
+	opts := ExportOptions{Duplicates: DupConcatReverse, DupSep: ", "}
+	tu.Equal(t, AsTypWith(&b, pres, "Presentations", opts), nil)
+	tu.Equal(t, strings.Contains(b.String(), "ECCMID.Madrid, Spain."), true)
+
+	const src = `@misc{a, title = {T}, address = {Spain}, address = {Madrid}}
+@misc{b, title = {T}, address = {Spain}, address = {Barcelona}}`
+	g, err := Parse(strings.NewReader(src), "dup", Options{})
+	tu.Equal(t, err, nil, tu.FailNow)
+	_, dr, err := Deduplicate([]*File{g}, []string{"title", "address"}, SetNoAction)
+	tu.Equal(t, err, nil)
+	tu.Equal(t, dr.DuplicateSetCount, 1)
+	_, dr, err = DeduplicateWith([]*File{g}, []string{"title", "address"}, SetNoAction, DedupOptions{Duplicates: DupConcat})
+	tu.Equal(t, err, nil)
+	tu.Equal(t, dr.DuplicateSetCount, 0)
+
+	g, err = Parse(strings.NewReader(src), "dup", Options{Duplicates: DupConcatReverse, DupSep: ", "})
+	tu.Equal(t, err, nil, tu.FailNow)
+	tu.Equal(t, g.Records[0].FieldValues("address"), []string{"Madrid, Spain"})
+	tu.Equal(t, g.Records[0].Repeated(), []string(nil))
+}
+
+func TestJSON(t *testing.T) {
+	const src = `@string{acs = "American Chemical Society"}
+@article(k1,
+  publisher = acs # " Press",
+  address = {Spain}, address = {Madrid},
+  year = 2001)
+`
+	f, err := Parse(strings.NewReader(src), "in.bib", Options{KeepMacros: true})
+	tu.Equal(t, err, nil, tu.FailNow)
+	data, err := json.Marshal(f)
+	tu.Equal(t, err, nil, tu.FailNow)
+	tu.Equal(t, strings.Contains(string(data), `"macros":[{"name":"acs","value":"American Chemical Society","delim":"quote","line":1}]`), true)
+	tu.Equal(t, strings.Contains(string(data), `{"name":"year","value":"2001","delim":"none","line":5,`+
+		`"nameSpan":{"start":{"line":5,"column":3,"offset":129},"end":{"line":5,"column":7,"offset":133}},`+
+		`"valueSpan":{"start":{"line":5,"column":10,"offset":136},"end":{"line":5,"column":14,"offset":140}}}`), true)
+	g, err := ParseJSON(bytes.NewReader(data), "")
+	tu.Equal(t, err, nil, tu.FailNow)
+	tu.Equal(t, g.Name(), "in.bib")
+	tu.Equal(t, g.Records, f.Records)
+	v, _ := g.Macro("ACS")
+	tu.Equal(t, v, "American Chemical Society")
+	var b1, b2 strings.Builder
+	Print(&b1, f)
+	Print(&b2, g)
+	tu.Equal(t, b2.String(), b1.String())
+
+	f = parseTestFile(t, "./tests/scholar.bib")
+	data, err = json.MarshalIndent(f, "", " ")
+	tu.Equal(t, err, nil, tu.FailNow)
+	g, err = ParseJSON(bytes.NewReader(data), "scholar.json")
+	tu.Equal(t, err, nil, tu.FailNow)
+	tu.Equal(t, g.Records, f.Records)
+
+	// tests/sub/article.json holds tests/sub/article.bib
+	r, err := os.Open("./tests/sub/article.json")
+	tu.Equal(t, err, nil, tu.FailNow)
+	defer r.Close()
+	g, err = ParseJSON(r, "")
+	tu.Equal(t, err, nil, tu.FailNow)
+	f = parseTestFile(t, "tests/sub/article.bib")
+	tu.Equal(t, g.Name(), f.Name())
+	tu.Equal(t, g.RecordCount(), 255)
+	tu.Equal(t, g.Records, f.Records)
+
+	_, err = ParseJSON(strings.NewReader(`{"records":[{"key":"x","fields":[]}]}`), "bad.json")
+	tu.Equal(t, err.Error(), "can't parse bad.json: record x without a type")
+	_, err = ParseJSON(strings.NewReader(`{"records":[{"type":"misc","fields":[{"name":"a","delim":"square"}]}]}`), "bad.json")
+	tu.Equal(t, err.Error(), `can't parse bad.json: invalid delimiter "square"`)
+}
+
+func TestCloneEqualDiff(t *testing.T) {
+	const src = `@string{j = {Nature}}
+@Article{Doe2020,
+  Title = {A   Study},
+  journal = j,
+  address = {Spain}, address = {Madrid},
+  abstract = {Long}
+}
+% between
+@book{other, title = {B}}
+`
+	f, err := Parse(strings.NewReader(src), "a.bib", Options{Lossless: true})
+	tu.Equal(t, err, nil, tu.FailNow)
+	g := f.Clone()
+	tu.Equal(t, g.Records, f.Records)
+	tu.Equal(t, g.Records[0] != f.Records[0], true)
+	tu.Equal(t, len(g.Nodes()), len(f.Nodes()))
+	tu.Equal(t, g.Nodes()[2] == Node(g.Records[0]), true)
+	g.Records[0].SetField("title", "Changed")
+	g.setMacro("k", "v")
+	tu.Equal(t, f.Records[0].Field("title"), "A   Study")
+	tu.Equal(t, f.MacroNames(), []string{"j"})
+	var b strings.Builder
+	Print(&b, f)
+	tu.Equal(t, b.String(), src)
+
+	h, err := Parse(strings.NewReader(`@article{doe2020, journal = "nature",
+  title = {a study}, address = "Spain", address = {Madrid}, abstract = {Long}}`), "b.bib", Options{})
+	tu.Equal(t, err, nil, tu.FailNow)
+	a, c := f.Records[0], h.Records[0]
+	tu.Equal(t, a.Equal(c), true)
+	tu.Equal(t, DiffRecords(a, c), []FieldDiff(nil))
+	c.SetField("journal", "Science")
+	c.DeleteField("abstract")
+	c.addField(Field{key: "address", value: "Barcelona"})
+	c.SetField("doi", "10.1/x")
+	tu.Equal(t, a.Equal(c), false)
+	tu.Equal(t, DiffRecords(a, c), []FieldDiff{
+		{Name: "journal", Kind: FieldChanged, Old: "Nature", New: "Science"},
+		{Name: "abstract", Kind: FieldRemoved, Old: "Long"},
+		{Name: "address", Kind: FieldAdded, New: "Barcelona"},
+		{Name: "doi", Kind: FieldAdded, New: "10.1/x"},
+	})
+	tu.Equal(t, FieldRemoved.String(), "removed")
+
+	merged, _, err := Deduplicate([]*File{f, h}, []string{"year", "title"}, SetUnion)
+	tu.Equal(t, err, nil, tu.FailNow)
+	_, err = FixKeys(merged, nil, true)
+	tu.Equal(t, err, nil)
+	tu.Equal(t, f.Records[0].Key(), "Doe2020")
+	tu.Equal(t, h.Records[0].Key(), "doe2020")
+}
+
+func TestKeyIndex(t *testing.T) {
+	const src = `@misc{A1, title = {One}}
+% keep me
+@misc{b2, title = {Two}}
+@misc{c3, title = {Three}}
+`
+	f, err := Parse(strings.NewReader(src), "index", Options{Lossless: true})
+	tu.Equal(t, err, nil, tu.FailNow)
+	tu.Equal(t, f.Lookup("a1").Field("title"), "One")
+	tu.Equal(t, f.Contains("B2"), true)
+	tu.Equal(t, f.Contains("d4"), false)
+	tu.Equal(t, f.Lookup("d4"), (*Record)(nil))
+
+	tu.Equal(t, f.Rename("b2", "c3"), errors.New("key c3 is already used"))
+	tu.Equal(t, f.Rename("x", "y"), errors.New("no record with key x"))
+	tu.Equal(t, f.Rename("b2", "Bee"), nil)
+	tu.Equal(t, f.Contains("b2"), false)
+	tu.Equal(t, f.Lookup("bee").Field("title"), "Two")
+
+	rec := NewRecord("book", "d4")
+	rec.SetField("title", "Four")
+	tu.Equal(t, f.Replace("C3", rec), true)
+	tu.Equal(t, f.Replace("c3", rec), false)
+	tu.Equal(t, f.Lookup("D4"), rec)
+	tu.Equal(t, f.Remove("a1"), true)
+	tu.Equal(t, f.Remove("a1"), false)
+	var b strings.Builder
+	Print(&b, f)
+	tu.Equal(t, b.String(), "\n% keep me\n@misc{Bee, title = {Two}}\n\n@book{d4,\ntitle={Four},\n}\n\n")
+
+	// direct changes to Records are noticed
+	f.AddRecord(NewRecord("misc", "e5"))
+	tu.Equal(t, f.Contains("e5"), true)
+	f.Records[0], f.Records[1] = f.Records[1], f.Records[0]
+	tu.Equal(t, f.Lookup("bee").Key(), "Bee")
+	tu.Equal(t, f.Lookup("d4"), rec)
+	tu.Equal(t, Sort(f, "type,-year"), nil)
+	tu.Equal(t, f.Records[0], rec)
+	tu.Equal(t, f.Lookup("e5").Key(), "e5")
+	other := NewRecord("misc", "f6")
+	f.Records[0] = other
+	tu.Equal(t, f.Lookup("f6"), other)
+	other.SetKey("g7")
+	tu.Equal(t, f.Lookup("g7"), other)
+	tu.Equal(t, f.Contains("f6"), false)
+}
+
+func TestParseNames(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Person
+	}{
+		{"Mahmud, Salaheddin", Person{First: "Salaheddin", Last: "Mahmud", Initials: "S"}},
+		{"Mahmud SM", Person{Last: "Mahmud", Initials: "SM"}},
+		{"Mahmud, S. M.", Person{First: "S. M.", Last: "Mahmud", Initials: "SM"}},
+		{"Mahmud, SM", Person{Last: "Mahmud", Initials: "SM"}},
+		{"Salaheddin M Mahmud", Person{First: "Salaheddin M", Last: "Mahmud", Initials: "SM"}},
+		{"Van Aalst R [S]", Person{Last: "Van Aalst", Initials: "R", Markers: []string{"S"}}},
+		{"van der Berg JA Jr", Person{Von: "van der", Last: "Berg", Jr: "Jr", Initials: "JA"}},
+		{"Young-Xu Y", Person{Last: "Young-Xu", Initials: "Y"}},
+		{"Thornton Snider J", Person{Last: "Thornton Snider", Initials: "J"}},
+		{"Singh H.", Person{Last: "Singh", Initials: "H"}},
+		{"Montalban J. M.", Person{Last: "Montalban", Initials: "JM"}},
+		{"Montalban J.M.", Person{Last: "Montalban", Initials: "JM"}},
+		{"Dupont J.-P. Jr", Person{Last: "Dupont", Jr: "Jr", Initials: "JP"}},
+		{"J. M. Montalban", Person{First: "J. M.", Last: "Montalban", Initials: "JM"}},
+		{`Coutl{\'e}e, Fran{\c{c}}ois`, Person{First: `Fran{\c{c}}ois`, Last: `Coutl{\'e}e`, Initials: "F"}},
+		{"Ludwig van Beethoven", Person{First: "Ludwig", Von: "van", Last: "Beethoven", Initials: "L"}},
+		{"Jean de la Fontaine", Person{First: "Jean", Von: "de la", Last: "Fontaine", Initials: "J"}},
+		{`{\'E}mile~Zola`, Person{First: `{\'E}mile`, Last: "Zola", Initials: "E"}},
+		{`{\'e}tienne de Gaulle`, Person{Von: `{\'e}tienne de`, Last: "Gaulle"}},
+		{`de la Vall{\'e}e Poussin, Jean-Charles`, Person{First: "Jean-Charles", Von: "de la", Last: `Vall{\'e}e Poussin`, Initials: "JC"}},
+		{"Ford, Jr., Henry", Person{First: "Henry", Last: "Ford", Jr: "Jr.", Initials: "H"}},
+		{"{World Health Organization}", Person{Last: "{World Health Organization}"}},
+		{"others", Person{Last: "others"}},
+	}
+	for _, test := range tests {
+		tu.Equal(t, ParseName(test.in), test.want)
+	}
+	names := ParseNames("Rimmer E [S] and Houston DS AND {Barnes and Noble} and others")
+	tu.Equal(t, len(names), 4, tu.FailNow)
+	tu.Equal(t, names[1].Last, "Houston")
+	tu.Equal(t, names[2].Last, "{Barnes and Noble}")
+	tu.Equal(t, names[3].IsOthers(), true)
+	tu.Equal(t, ParseNames(""), []Person(nil))
+
+	rec := NewRecord("misc", "")
+	rec.SetField("author", "Van Aalst R and Mahmud SM")
+	rec.SetField("editor", "Coutl{\\'e}e, Fran{\\c{c}}ois")
+	rec.SetField("year", "2018")
+	rec.SetField("title", "Analysis of vaccines")
+	tu.Equal(t, rec.Authors()[1].Initials, "SM")
+	tu.Equal(t, rec.Editors()[0].Last, "Coutl{\\'e}e")
+	tu.Equal(t, NewCiteKey(rec), "vanaalst2018analysism")
+	rec.SetField("author", rec.Field("editor"))
+	tu.Equal(t, NewCiteKey(rec), "coutlee2018analysism")
+	rec.SetField("author", "Singh H. and Montalban J. M.")
+	tu.Equal(t, NewCiteKey(rec), "singh2018analysism")
+}
+
+func TestFormatNames(t *testing.T) {
+	okoli := ParseName("Okoli GN [S]")
+	okoli.First = "George N."
+	wilkinson := ParseName("Wilkinson, Krista")
+	vdb := ParseName("van der Berg JA Jr")
+	tests := []struct {
+		style NameStyle
+		want  []string
+	}{
+		{NamesVancouver, []string{"Okoli GN [S]", "Wilkinson K", "van der Berg JA Jr"}},
+		{NamesAPA, []string{"Okoli, G. N. [S]", "Wilkinson, K.", "van der Berg, J. A., Jr"}},
+		{NamesFull, []string{"George N. Okoli [S]", "Krista Wilkinson", "J. A. van der Berg Jr"}},
+		{NamesLastFirst, []string{"Okoli, George N. [S]", "Wilkinson, Krista", "van der Berg, J. A., Jr"}},
+	}
+	for _, test := range tests {
+		for i, p := range []Person{okoli, wilkinson, vdb} {
+			tu.Equal(t, p.Format(test.style), test.want[i])
+		}
+	}
+	names := ParseNames("Okoli GN and Righolt CH and Mahmud SM")
+	tu.Equal(t, FormatNames(names, NamesVancouver), "Okoli GN, Righolt CH, Mahmud SM")
+	tu.Equal(t, FormatNames(names, NamesAPA), "Okoli, G. N., Righolt, C. H., & Mahmud, S. M.")
+	tu.Equal(t, FormatNames(names[:2], NamesFull), "G. N. Okoli and C. H. Righolt")
+	tu.Equal(t, FormatNames(ParseNames("Wilkinson, Krista and others"), NamesLastFirst), "Wilkinson, Krista, et al.")
+
+	f := &File{}
+	for i, author := range []string{"Okoli GN and Righolt CH", "Wilkinson, Krista and Righolt, Christiaan H"} {
+		rec := NewRecord("article", fmt.Sprint("r", i))
+		rec.SetField("author", author)
+		f.AddRecord(rec)
+	}
+	var b strings.Builder
+	tu.Equal(t, AsTypWith(&b, f, "Articles", ExportOptions{Names: NamesVancouver}), nil)
+	tu.Equal(t, strings.Contains(b.String(), "[Okoli GN, Righolt CH."), true)
+	tu.Equal(t, strings.Contains(b.String(), "[Wilkinson K, Righolt CH."), true)
+}
+
+func TestLaTeX(t *testing.T) {
+	decodeTests := []struct{ in, want string }{
+		{`Jo{\~a}o`, "João"},
+		{`Coutl{\'e}e`, "Coutlée"},
+		{`Coutl\'ee`, "Coutlée"},
+		{`Fran{\c{c}}ois and Fran\c cois`, "François and François"},
+		{`Stra\ss e and {\O}stergaard and Sm\o{}rgrav`, "Straße and Østergaard and Smørgrav"},
+		{`Erd\H{o}s, Ho\v{r}ej\v{s}\'{\i}`, "Erdős, Hořejší"},
+		{`\t{oo}`, "o͡o"},
+		{`Johnson \& Johnson, 50\% off`, "Johnson & Johnson, 50% off"},
+		{`pages 1--10, A---B`, "pages 1–10, A—B"},
+		{"``quoted''", "“quoted”"},
+		{`{\'E}mile~Zola`, "Émile Zola"},
+		{`$\alpha$-synuclein in H$_2$O at 10$^{-3}$ and 25$^\circ$C, age $\geq$65`,
+			"α-synuclein in H₂O at 10⁻³ and 25°C, age ≥65"},
+		{`{\em Helicobacter pylori} \textit{in vitro}`, "Helicobacter pylori in vitro"},
+		{`{COVID-19} vaccines`, "{COVID-19} vaccines"},
+		{`\unknown{x} and $x$ and $5`, `\unknown{x} and x and $5`},
+	}
+	for _, test := range decodeTests {
+		tu.Equal(t, DecodeLaTeX(test.in), test.want)
+	}
+	encodeTests := []struct{ in, want string }{
+		{"João", `Jo{\~a}o`},
+		{"François Straße", `Fran{\c{c}}ois Stra{\ss}e`},
+		{"Johnson & Johnson, 50% off, \\& kept", `Johnson \& Johnson, 50\% off, \& kept`},
+		{"1–10", "1--10"},
+		{"αβ²³ and H₂O", `$\alpha\beta^{23}$ and H$_{2}$O`},
+		{"Émile Zola", `{\'E}mile~Zola`},
+	}
+	for _, test := range encodeTests {
+		tu.Equal(t, EncodeLaTeX(test.in), test.want)
+	}
+	for _, s := range []string{"Hořejší & Erdős", "Smørgrav — 25°C ≥ 5", "a_b #1 ~ “x”", "Ñandú ⁽ⁿ⁾"} {
+		tu.Equal(t, DecodeLaTeX(EncodeLaTeX(s)), s)
+	}
+
+	src := `@article{a, author = {Coutl{\'e}e, Fran{\c{c}}ois and Jo{\~a}o Silva},
+  title = {{COVID-19} in {\O}stergaard},
+  url = {http://example.com/~user/a\_b}}`
+	f, err := Parse(strings.NewReader(src), "", Options{DecodeLaTeX: true})
+	tu.Equal(t, err, nil, tu.FailNow)
+	rec := f.Records[0]
+	tu.Equal(t, rec.Field("author"), "Coutlée, François and João Silva")
+	tu.Equal(t, rec.Field("title"), "{COVID-19} in Østergaard")
+	tu.Equal(t, rec.Field("url"), `http://example.com/~user/a\_b`)
+
+	var b bytes.Buffer
+	tu.Equal(t, PrintWith(&b, rec, PrintOptions{EncodeLaTeX: true}), nil)
+	tu.Equal(t, strings.Contains(b.String(), `author={Coutl{\'e}e, Fran{\c{c}}ois and Jo{\~a}o Silva}`), true)
+	tu.Equal(t, strings.Contains(b.String(), `url={http://example.com/~user/a\_b}`), true)
+	tu.Equal(t, rec.Field("title"), "{COVID-19} in Østergaard")
+
+	c := rec.Clone()
+	c.EncodeLaTeX()
+	tu.Equal(t, c.Field("title"), `{COVID-19} in {\O}stergaard`)
+	c.DecodeLaTeX()
+	tu.Equal(t, c.Equal(rec), true)
+
+	f, err = Parse(strings.NewReader(src), "", Options{})
+	tu.Equal(t, err, nil, tu.FailNow)
+	b.Reset()
+	tu.Equal(t, AsTypWith(&b, f, "Articles", ExportOptions{DecodeLaTeX: true, Names: NamesVancouver}), nil)
+	tu.Equal(t, strings.Contains(b.String(), "[Coutlée F, Silva J._COVID-19 in Østergaard_"), true)
+
+	// values that are still LaTeX are not encoded twice
+	src = `@article{b, author = {Jo{\~a}o Silva and A~B and Zoë},
+  title = {{\em In vitro} \emph{Salmonella} \& 50\% \{x\} at 10$^{-3}$}}`
+	f, err = Parse(strings.NewReader(src), "", Options{})
+	tu.Equal(t, err, nil, tu.FailNow)
+	b.Reset()
+	tu.Equal(t, PrintWith(&b, f.Records[0], PrintOptions{EncodeLaTeX: true}), nil)
+	tu.Equal(t, strings.Contains(b.String(), `author={Jo{\~a}o Silva and A~B and Zo{\"e}}`), true)
+	tu.Equal(t, strings.Contains(b.String(), `title={{\em In vitro} \emph{Salmonella} \& 50\% \{x\} at 10$^{-3}$}`), true)
+	c = f.Records[0].Clone()
+	c.EncodeLaTeX()
+	tu.Equal(t, c.Field("author"), `Jo{\~a}o Silva and A~B and Zo{\"e}`)
+	c.EncodeLaTeX()
+	tu.Equal(t, c.Field("author"), `Jo{\~a}o Silva and A~B and Zo{\"e}`)
+}
+
+func TestValues(t *testing.T) {
+	rec := NewRecord("article", "a")
+	year := func(s string) int {
+		rec.SetField("year", s)
+		y, ok := rec.Year()
+		if !ok {
+			return -1
+		}
+		return y
+	}
+	tu.Equal(t, year("2019"), 2019)
+	tu.Equal(t, year("2019a"), 2019)
+	tu.Equal(t, year("c. 2019"), 2019)
+	tu.Equal(t, year("2019--2020"), 2019)
+	tu.Equal(t, year("12345"), -1)
+	tu.Equal(t, year("in press"), -1)
+
+	month := func(s string) int {
+		rec.SetField("month", s)
+		m, ok := rec.Month()
+		if !ok {
+			return -1
+		}
+		return m
+	}
+	tu.Equal(t, month("feb"), 2)
+	tu.Equal(t, month("February"), 2)
+	tu.Equal(t, month("Feb."), 2)
+	tu.Equal(t, month("2"), 2)
+	tu.Equal(t, month("02"), 2)
+	tu.Equal(t, month("Sep-Oct"), 9)
+	tu.Equal(t, month("13"), -1)
+	tu.Equal(t, month("fe"), -1)
+
+	pages := func(s string) string {
+		rec.SetField("pages", s)
+		first, last, ok := rec.Pages()
+		if !ok {
+			return "none"
+		}
+		return first + ":" + last
+	}
+	tu.Equal(t, pages("213-221"), "213:221")
+	tu.Equal(t, pages("213--221"), "213:221")
+	tu.Equal(t, pages("pp. 1234–56"), "1234:1256")
+	tu.Equal(t, pages("e0123456"), "e0123456:e0123456")
+	tu.Equal(t, pages("S12-S18"), "S12:S18")
+	tu.Equal(t, pages(" "), "none")
+
+	rec.SetField("volume", " 12 ")
+	rec.SetField("number", "3")
+	tu.Equal(t, rec.Volume(), "12")
+	tu.Equal(t, rec.Issue(), "3")
+	rec.SetField("issue", "4")
+	tu.Equal(t, rec.Issue(), "4")
+
+	tu.Equal(t, rec.DOI(), "")
+	rec.SetField("url", "http://www.ncbi.nlm.nih.gov/pubmed/23677791")
+	tu.Equal(t, rec.PMID(), "23677791")
+	rec.SetField("url", "https://doi.org/10.1016/J.Vaccine.2019.01.001")
+	tu.Equal(t, rec.DOI(), "10.1016/j.vaccine.2019.01.001")
+	rec.SetField("doi", "doi: 10.1000/XYZ")
+	tu.Equal(t, rec.DOI(), "10.1000/xyz")
+	rec.SetField("doi", "10.1002/(SICI)1097-0258(19980715)17:13<1495::AID-SIM863>3.0.CO;2-I")
+	tu.Equal(t, rec.DOI(), "10.1002/(sici)1097-0258(19980715)17:13<1495::aid-sim863>3.0.co;2-i")
+	rec.SetField("doi", "")
+	rec.SetField("url", "")
+	rec.SetField("note", "See <https://doi.org/10.1000/XYZ>.")
+	tu.Equal(t, rec.DOI(), "10.1000/xyz")
+	rec.SetField("note", "")
+	rec.SetField("eprinttype", "pubmed")
+	rec.SetField("eprint", "123")
+	tu.Equal(t, rec.PMID(), "123")
+	rec.SetField("pmid", "PMID: 456")
+	tu.Equal(t, rec.PMID(), "456")
+
+	rec.SetField("isbn", "ISBN-10: 0-306-40615-2")
+	tu.Equal(t, rec.ISBN(), "9780306406157")
+	rec.SetField("isbn", "978-0-306-40615-7; 0-306-40615-2")
+	tu.Equal(t, rec.ISBN(), "9780306406157")
+	rec.SetField("isbn", "0306406152 9781234567897")
+	tu.Equal(t, rec.ISBN(), "9780306406157")
+	rec.SetField("isbn", "9780306406157 0-19-853453-1")
+	tu.Equal(t, rec.ISBN(), "9780306406157")
+	rec.SetField("isbn", "978 0 306 40615 7")
+	tu.Equal(t, rec.ISBN(), "9780306406157")
+	rec.SetField("isbn", "978-0-306-40615-8")
+	tu.Equal(t, rec.ISBN(), "")
+	rec.SetField("issn", "0378 5955")
+	tu.Equal(t, rec.ISSN(), "0378-5955")
+	rec.SetField("issn", "0378-5955 1234-5679")
+	tu.Equal(t, rec.ISSN(), "0378-5955")
+	rec.SetField("issn", "2434-561x")
+	tu.Equal(t, rec.ISSN(), "2434-561X")
+	rec.SetField("issn", "2434-5612")
+	tu.Equal(t, rec.ISSN(), "")
+
+	f := &File{}
+	for i, y := range []string{"2018", "", "2020b"} {
+		r := NewRecord("article", fmt.Sprint(i))
+		r.SetField("year", y)
+		f.AddRecord(r)
+	}
+	tu.Equal(t, Sort(f, "type,-year"), nil)
+	tu.Equal(t, f.Records[0].Key(), "1")
+	tu.Equal(t, f.Records[1].Key(), "2")
+}
+
+func TestParseDate(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"2012", "2012"},
+		{"2012-11", "2012-11"},
+		{"2012-11-06~", "2012-11-06~"},
+		{"2012-21", "2012-21"},
+		{"2004-04-05T14:34:00", "2004-04-05"},
+		{"-0044-03-15", "-0044-03-15"},
+		{"1998-05/2001", "1998-05/2001"},
+		{"1998?/2001%", "1998?/2001%"},
+		{"1998/", "1998/"},
+		{"../2001", "../2001"},
+		{"199X", "1990/1999"},
+		{"19XX", "1900/1999"},
+		{"2012-XX", "2012"},
+	}
+	for _, test := range tests {
+		d, err := ParseDate(test.in)
+		tu.Equal(t, err, nil)
+		tu.Equal(t, d.String(), test.want)
+	}
+	d, err := ParseDate("1968-05-19/1968-05-25")
+	tu.Equal(t, err, nil, tu.FailNow)
+	tu.Equal(t, d, Date{
+		Start:   DatePart{Year: 1968, Month: 5, Day: 19, Precision: PrecisionDay},
+		End:     DatePart{Year: 1968, Month: 5, Day: 25, Precision: PrecisionDay},
+		IsRange: true,
+	})
+	d, _ = ParseDate("2012-11~")
+	tu.Equal(t, d, Date{
+		Start: DatePart{Year: 2012, Month: 11, Precision: PrecisionMonth, Circa: true},
+		End:   DatePart{Year: 2012, Month: 11, Precision: PrecisionMonth, Circa: true},
+	})
+	for _, s := range []string{"", "12", "2012-13", "2019-02-29", "2012-21-01", "2001/1998", "../..", "1998/2001/2004", "199X-05", "abcd", "2012-1"} {
+		_, err := ParseDate(s)
+		tu.Equal(t, err != nil, true)
+	}
+
+	rec := NewRecord("inproceedings", "a")
+	rec.SetField("date", "1998-05/2001")
+	rec.SetField("eventdate", "1998-05-19/1998-05-25")
+	y, ok := rec.Year()
+	tu.Equal(t, ok, true)
+	tu.Equal(t, y, 1998)
+	m, _ := rec.Month()
+	tu.Equal(t, m, 5)
+	d, err = rec.DateField("eventdate")
+	tu.Equal(t, err, nil)
+	tu.Equal(t, d.End.Day, 25)
+	_, err = rec.DateField("urldate")
+	tu.Equal(t, err != nil, true)
+	rec.SetField("date", "../2001")
+	y, _ = rec.Year()
+	tu.Equal(t, y, 2001)
+	rec.SetField("year", "2000")
+	y, _ = rec.Year()
+	tu.Equal(t, y, 2000)
+
+	f := parseTestFile(t, "tests/biblatex-examples.bib")
+	n := 0
+	for _, rec := range f.Records {
+		for _, name := range []string{"date", "urldate", "eventdate", "origdate"} {
+			if rec.Field(name) == "" {
+				continue
+			}
+			_, err := rec.DateField(name)
+			tu.Equal(t, err, nil)
+			n++
+		}
+		if rec.Field("date") != "" {
+			_, ok := rec.Year()
+			tu.Equal(t, ok, true)
+		}
+	}
+	tu.Equal(t, n > 80, true)
+}
+
 func parseTestFile(t *testing.T, filename string) *File {
 	t.Helper()
 	n, err := Parse(nil, filename, Options{})
@@ -132,6 +1290,197 @@ func TestParseFile(t *testing.T) {
 // 	fmt.Println(dr)
 // }
 
+func TestDedupFuzzy(t *testing.T) {
+	newFile := func(name string, recs ...[4]string) *File {
+		f := newRoot(name)
+		for _, r := range recs {
+			rec := NewRecord("article", r[0])
+			rec.SetField("author", r[1])
+			rec.SetField("title", r[2])
+			rec.SetField("year", r[3])
+			f.AddRecord(rec)
+		}
+		return f
+	}
+	ccv := newFile("ccv.bib",
+		[4]string{"a1", "Young-Xu Y and Van Aalst R [S] and Mahmud SM", "Influenza vaccine effectiveness among US veterans.", "2018"},
+		[4]string{"a2", "Okoli GN and Righolt CH", "Seasonal influenza vaccination in older people", "2020"},
+		[4]string{"a3", "Mahmud SM", "Untitled report", "2019"},
+	)
+	scholar := newFile("scholar.bib",
+		[4]string{"b1", "Young-Xu, Yinong and Van Aalst, Robertus and others", "Influenza vaccine effectiveness among {US} veterans", "2018"},
+		[4]string{"b2", "Okoli, George N and Righolt, Christiaan H", "Seasonal influenza vaccination in elderly people", "2020"},
+		[4]string{"b3", "Mahmud, Salaheddin", "Untitled report", "2017"},
+		[4]string{"b4", "Wilkinson, Krista", "Seasonal influenza vaccination in older people", "2020"},
+	)
+	files := []*File{ccv, scholar}
+	_, dr, err := DeduplicateWith(files, nil, SetNoAction, DedupOptions{Fuzzy: true})
+	tu.Equal(t, err, nil, tu.FailNow)
+	tu.Equal(t, dr.DuplicateSetCount, 2)
+	tu.Equal(t, len(dr.Pairs), 2, tu.FailNow)
+	p := dr.Pairs[0]
+	tu.Equal(t, len(dr.DuplicateSet[p.Set]), 2)
+	tu.Equal(t, p.A.Node.Key()+p.B.Node.Key(), "a1b1")
+	tu.Equal(t, p.Title > 0.99, true)
+	tu.Equal(t, p.Authors, 1.0)
+	p = dr.Pairs[1]
+	tu.Equal(t, p.A.Node.Key()+p.B.Node.Key(), "a2b2")
+	tu.Equal(t, p.Title >= 0.9 && p.Title < 1, true)
+	tu.Equal(t, strings.Contains(dr.String(), "ccv.bib:0 ~ scholar.bib:0: "), true)
+
+	_, dr, _ = DeduplicateWith(files, nil, SetNoAction, DedupOptions{Fuzzy: true, TitleThreshold: 0.99})
+	tu.Equal(t, dr.DuplicateSetCount, 1)
+	_, dr, _ = DeduplicateWith(files, nil, SetNoAction, DedupOptions{Fuzzy: true, YearTolerance: 2})
+	tu.Equal(t, dr.DuplicateSetCount, 3)
+	merged, dr, _ := DeduplicateWith(files, nil, SetUnion, DedupOptions{Fuzzy: true, AuthorThreshold: 0.01})
+	tu.Equal(t, dr.DuplicateSetCount, 2)
+	tu.Equal(t, merged.RecordCount(), 5)
+	tu.Equal(t, len(dr.Pairs), 2)
+
+	same := newFile("same.bib",
+		[4]string{"s1", "Okoli GN", "Seasonal influenza vaccination in older people", "2020"},
+		[4]string{"s2", "Okoli GN", "Seasonal influenza vaccination in older people.", "2020"},
+		[4]string{"s3", "Okoli, George N", "Seasonal Influenza Vaccination in Older People", "2020"},
+	)
+	_, dr, _ = DeduplicateWith([]*File{same}, nil, SetNoAction, DedupOptions{Fuzzy: true})
+	tu.Equal(t, dr.DuplicateSetCount, 1)
+	tu.Equal(t, len(dr.Pairs), 2) // only the pairs that were linked
+
+	// papers of one group whose long titles differ by a few words
+	salah := parseTestFile(t, "tests/salah.bib")
+	scholarFile := parseTestFile(t, "tests/scholar.bib")
+	_, dr, _ = DeduplicateWith([]*File{salah, scholarFile}, nil, SetNoAction, DedupOptions{Fuzzy: true})
+	sets := make(map[string]string) // the set of each title
+	for key, nodes := range dr.DuplicateSet {
+		for _, n := range nodes {
+			sets[n.Node.Field("title")] = key
+		}
+	}
+	for _, pair := range [][2]string{
+		{"Human Papillomavirus Vaccination and Anogenital Warts in Manitoba",
+			"Human Papillomavirus Vaccination and Cervical Cancer Screening in Manitoba"},
+		{"Quadrivalent HPV vaccination and the incidence of anogenital warts in Manitoba, Canada",
+			"Quadrivalent HPV vaccination and the incidence of cervical dysplasia in Manitoba, Canada"},
+	} {
+		tu.Equal(t, sets[pair[0]] != "" && sets[pair[1]] != "", true, tu.FailNow)
+		tu.Equal(t, sets[pair[0]] == sets[pair[1]], false)
+	}
+
+	var recs []fuzzyRecord
+	for _, f := range files {
+		for _, rec := range f.Records {
+			recs = append(recs, newFuzzyRecord(NodeInfo{rec, f}, DedupOptions{}))
+		}
+	}
+	var candidates [][]int
+	blocks := newFuzzyBlocks(recs, DedupOptions{})
+	for i := range recs {
+		candidates = append(candidates, blocks.candidates(i))
+	}
+	tu.Equal(t, candidates, [][]int{nil, nil, nil, {0}, {1}, nil, {1, 4}})
+
+	tu.Equal(t, jaroWinkler("martha", "marhta") > 0.96, true)
+	tu.Equal(t, jaroWinkler("", ""), 1.0)
+	tu.Equal(t, jaroWinkler("abc", "xyz"), 0.0)
+}
+
+func TestDedupIdentifiers(t *testing.T) {
+	newFile := func(name string, recs ...map[string]string) *File {
+		f := newRoot(name)
+		for i, fields := range recs {
+			rec := NewRecord("article", fmt.Sprint(name[:1], i+1))
+			for _, k := range []string{"title", "year", "doi", "url", "note", "pmid", "isbn"} {
+				if v, ok := fields[k]; ok {
+					rec.SetField(k, v)
+				}
+			}
+			f.AddRecord(rec)
+		}
+		return f
+	}
+	ccv := newFile("ccv.bib",
+		map[string]string{"title": "Influenza vaccine: a trial", "year": "2020", "doi": "10.1000/ABC"},
+		map[string]string{"title": "Statins and cancer", "year": "2019", "note": "Epub ahead of print. PMID: 123456"},
+		map[string]string{"title": "Costing blood services", "year": "1998", "isbn": "0-306-40615-2"},
+		map[string]string{"title": "Editorial", "year": "2020", "doi": "10.1000/one"},
+		map[string]string{"title": "Causal diagrams", "year": "2017"},
+	)
+	scholar := newFile("scholar.bib",
+		map[string]string{"title": "Influenza vaccination. A randomised trial", "year": "2020", "url": "https://doi.org/10.1000/abc"},
+		map[string]string{"title": "Statin use and the risk of cancer", "year": "2019", "pmid": "123456"},
+		map[string]string{"title": "A guide to costing blood services", "year": "1998", "isbn": "978-0-306-40615-7"},
+		map[string]string{"title": "Editorial", "year": "2020", "doi": "10.1000/two"},
+		map[string]string{"title": "Causal diagrams", "year": "2017", "doi": "10.1000/cd"},
+	)
+	files := []*File{ccv, scholar}
+	fields := []string{"year", "title"}
+	_, dr, err := Deduplicate(files, fields, SetNoAction)
+	tu.Equal(t, err, nil, tu.FailNow)
+	tu.Equal(t, dr.DuplicateSetCount, 2)
+
+	_, dr, err = DeduplicateWith(files, fields, SetNoAction, DedupOptions{Identifiers: true})
+	tu.Equal(t, err, nil, tu.FailNow)
+	tu.Equal(t, dr.DuplicateSetCount, 4)
+	var dups []string
+	for _, nodes := range dr.DuplicateSet {
+		if len(nodes) > 1 {
+			tu.Equal(t, len(nodes), 2)
+			dups = append(dups, nodes[0].Node.Key()+nodes[1].Node.Key())
+		}
+	}
+	slices.Sort(dups)
+	tu.Equal(t, dups, []string{"c1s1", "c2s2", "c3s3", "c5s5"})
+	merged, _, _ := DeduplicateWith(files, fields, SetUnion, DedupOptions{Identifiers: true})
+	tu.Equal(t, merged.RecordCount(), 6)
+
+	_, dr, _ = DeduplicateWith(files, nil, SetNoAction, DedupOptions{Identifiers: true, Fuzzy: true})
+	tu.Equal(t, dr.DuplicateSetCount, 4)
+	tu.Equal(t, len(dr.Pairs), 4)
+
+	// a record without a DOI does not join two records with different ones
+	f := newFile("f.bib",
+		map[string]string{"title": "Editorial", "year": "2020", "doi": "10.1000/one"},
+		map[string]string{"title": "Editorial", "year": "2020"},
+		map[string]string{"title": "Editorial", "year": "2020", "doi": "10.1000/two"},
+	)
+	for _, opts := range []DedupOptions{{Identifiers: true}, {Identifiers: true, Fuzzy: true}} {
+		_, dr, _ = DeduplicateWith([]*File{f}, fields, SetNoAction, opts)
+		tu.Equal(t, dr.DuplicateSetCount, 1)
+		tu.Equal(t, len(dr.DuplicateSet), 2)
+	}
+
+	// DOIs made of a SICI differ only after the angle bracket
+	sici := newFile("sici.bib",
+		map[string]string{"title": "Meta-analysis of trials", "year": "1998",
+			"doi": "10.1002/(SICI)1097-0258(19980715)17:13<1495::AID-SIM863>3.0.CO;2-I"},
+		map[string]string{"title": "Survival analysis", "year": "1998",
+			"doi": "10.1002/(SICI)1097-0258(19980715)17:13<1509::AID-SIM864>3.0.CO;2-O"},
+	)
+	_, dr, _ = DeduplicateWith([]*File{sici}, fields, SetNoAction, DedupOptions{Identifiers: true})
+	tu.Equal(t, dr.DuplicateSetCount, 0)
+
+	// chapters of a book share its ISBN
+	book := newRoot("book.bib")
+	for i, title := range []string{"Costing blood services", "Blood donors", "Blood donors"} {
+		rec := NewRecord("incollection", fmt.Sprint("i", i+1))
+		rec.SetField("title", title)
+		rec.SetField("year", "1998")
+		rec.SetField("isbn", "0-306-40615-2")
+		book.AddRecord(rec)
+	}
+	tu.Equal(t, identifiers(book.Records[0]), []string(nil))
+	_, dr, _ = DeduplicateWith([]*File{book}, fields, SetNoAction, DedupOptions{Identifiers: true})
+	tu.Equal(t, dr.DuplicateSetCount, 1)
+	tu.Equal(t, len(dr.DuplicateSet), 2)
+
+	rec := NewRecord("article", "x")
+	rec.SetField("note", "Available at doi:10.1000/XYZ.")
+	tu.Equal(t, rec.DOI(), "10.1000/xyz")
+	rec.SetField("url", "https://onlinelibrary.wiley.com/doi/10.1002/sim.1234")
+	tu.Equal(t, rec.DOI(), "10.1002/sim.1234")
+	tu.Equal(t, identifiers(rec), []string{"doi:10.1002/sim.1234"})
+}
+
 func TestOnlyASCIIAlphaNumeric(t *testing.T) {
 	tests := []struct {
 		in  string
@@ -199,8 +1548,8 @@ func TestDedupBib(t *testing.T) {
 		tu.P("%s %d records found\n", f.name, f.RecordCount())
 		tu.Equal(t, f.RecordCount(), expect)
 	}
-	bib1, err := Parse(strings.NewReader(bib1), "bib1", Options{})
-	pr(bib1, err, 3)
+	bib1 := parseBib1(t, Options{})
+	pr(bib1, nil, 3)
 	bib2, err := Parse(strings.NewReader(bib2), "bib2", Options{})
 	pr(bib2, err, 1)
 	res, dr, err := Deduplicate([]*File{bib2, bib1}, []string{"year", "title"}, SetNoAction)
@@ -0,0 +1,64 @@
+package bibsin
+
+import "io"
+
+// Scanner reads the records of a bibtex stream one at a time, holding in
+// memory only the record being read and the macros defined so far. It
+// accepts the same Options as Parse.
+//
+//	sc := NewScanner(r, "big.bib", Options{})
+//	for sc.Scan() {
+//		rec := sc.Record()
+//		...
+//	}
+//	if err := sc.Err(); err != nil {
+//		...
+//	}
+type Scanner struct {
+	p   *parser
+	rec *Record
+}
+
+// NewScanner returns a Scanner reading from r. fileName is used in error
+// messages.
+func NewScanner(r io.Reader, fileName string, opts Options) *Scanner {
+	return &Scanner{p: newParser(r, fileName, opts)}
+}
+
+// Scan advances to the next record. It returns false at the end of input
+// or, in strict mode, at the first problem.
+func (sc *Scanner) Scan() bool {
+	sc.rec = nil
+	for {
+		n := sc.p.next()
+		if n == nil {
+			return false
+		}
+		if rec, ok := n.(*Record); ok {
+			sc.rec = rec
+			return true
+		}
+	}
+}
+
+// Record returns the record read by the last call to Scan. Its Span gives
+// its position in the input.
+func (sc *Scanner) Record() *Record {
+	return sc.rec
+}
+
+// Errors returns the problems found so far.
+func (sc *Scanner) Errors() ParseErrors {
+	return sc.p.errs
+}
+
+// Err returns the error that Parse would have returned for the input read
+// so far: a read error or the ParseErrors found.
+func (sc *Scanner) Err() error {
+	return sc.p.err()
+}
+
+// Macro returns the value of the macro name as defined so far.
+func (sc *Scanner) Macro(name string) (string, bool) {
+	return sc.p.file.Macro(name)
+}
@@ -3,7 +3,6 @@ package bibsin
 import (
 	"fmt"
 	"sort"
-	"strconv"
 )
 
 const (
@@ -17,17 +16,18 @@ func Sort(f *File, flds string) error {
 	// special common case case
 	if flds == "type,-year" {
 		recs := f.Records
+		f.index = nil
 		sort.Slice(recs, func(i, j int) bool {
 			ni, nj := recs[i], recs[j]
 			if ni.value != nj.value {
 				return ni.value < nj.value //record type
 			}
-			yi, err := strconv.Atoi(ni.Field("year"))
-			if err != nil {
+			yi, ok := ni.Year()
+			if !ok {
 				yi = Missing
 			}
-			yj, err := strconv.Atoi(nj.Field("year"))
-			if err != nil {
+			yj, ok := nj.Year()
+			if !ok {
 				yj = Missing
 			}
 			return yi > yj // descending sort
@@ -0,0 +1,100 @@
+package bibsin
+
+import (
+	"io"
+	"strings"
+)
+
+// recordSyntax is the concrete syntax of a record kept by Parse in lossless
+// mode. A record reads head, then the source of each field, then tail.
+type recordSyntax struct {
+	head             string // from @ up to and including the comma after the key
+	typStart, typEnd int    // span of the entry type in head
+	keyStart, keyEnd int    // span of the citation key in head
+	tail             string // after the last field up to the closing delimiter
+	typ, key         string // type and key as parsed
+	pre, mid         string // layout used for fields added later
+	trailingComma    bool   // whether the last field was followed by a comma
+}
+
+// fieldSyntax is the concrete syntax of a field: its source reads
+// pre, name, mid, value, post.
+type fieldSyntax struct {
+	pre  string // white space before the name
+	mid  string // from the end of the name to the start of the value
+	src  string // the whole source of the field
+	post string // after the value including the separating comma if any
+	orig Field  // the field as parsed
+}
+
+func endsWithComma(s string) bool {
+	return strings.HasSuffix(strings.TrimSpace(s), ",")
+}
+
+// unchanged reports whether fld still holds what was parsed.
+func (fld *Field) unchanged() bool {
+	o := &fld.syn.orig
+	return fld.key == o.key && fld.value == o.value && fld.delim == o.delim && fld.raw == o.raw
+}
+
+// valueRepr returns the value of fld in bibtex syntax.
+func (fld *Field) valueRepr() string {
+	if fld.raw != "" {
+		return fld.raw
+	}
+	switch fld.delim {
+	case DelimQuote:
+		return `"` + fld.value + `"`
+	case DelimNone:
+		return fld.value
+	}
+	return "{" + fld.value + "}"
+}
+
+// writeSyntax writes a record parsed in lossless mode. Unchanged parts of
+// the record are written as they were read; a changed key, type or field is
+// written in the layout of its surroundings, as are fields, such as
+// inherited ones, that are not in the source.
+func (rec *Record) writeSyntax(w io.Writer, fields []Field) error {
+	syn := rec.syn
+	var sb strings.Builder
+	head := syn.head
+	if rec.key != syn.key || rec.value != strings.ToLower(syn.typ) {
+		typ := syn.typ
+		if rec.value != strings.ToLower(syn.typ) {
+			typ = rec.value
+		}
+		head = head[:syn.typStart] + typ + head[syn.typEnd:syn.keyStart] + rec.key + head[syn.keyEnd:]
+	}
+	if afterKey := syn.head[syn.keyEnd:]; len(fields) > 0 && !strings.Contains(afterKey, ",") {
+		// a record read without fields, as in @misc{k}
+		head = head[:len(head)-len(afterKey)] + "," + afterKey
+	}
+	sb.WriteString(head)
+	for i := range fields {
+		fld := &fields[i]
+		last := i == len(fields)-1
+		needComma := !last || syn.trailingComma
+		var src string
+		switch {
+		case fld.syn == nil:
+			src = syn.pre + fld.key + syn.mid + fld.valueRepr()
+		case fld.unchanged():
+			src = fld.syn.src
+		default:
+			src = fld.syn.pre + fld.key + fld.syn.mid + fld.valueRepr() + fld.syn.post
+		}
+		switch {
+		case needComma && !endsWithComma(src):
+			src += ","
+		case !needComma && endsWithComma(src):
+			// a field moved from the middle to the end
+			j := strings.LastIndexByte(src, COMMA)
+			src = src[:j] + src[j+1:]
+		}
+		sb.WriteString(src)
+	}
+	sb.WriteString(syn.tail)
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
@@ -0,0 +1,315 @@
+package bibsin
+
+import (
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// The accessors below read the fields of a record the same way for every
+// consumer. They look at the fields that a record inherits, as found by
+// File.Resolve, when it has none of its own.
+
+// firstField returns the first non-empty value of the fields names of rec.
+func (rec *Record) firstField(names ...string) string {
+	for _, name := range names {
+		if v := strings.TrimSpace(rec.ResolvedField(name)); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// Year returns the year of rec and whether it has one. The first run of
+// four digits in the year field counts, so "2019a", "c. 2019" and
+// "2019--2020" all give 2019. Without a year field, the year is that of
+// the start of the date field, or of its end if the range has no start.
+func (rec *Record) Year() (int, bool) {
+	if y, ok := parseYear(rec.firstField("year")); ok {
+		return y, true
+	}
+	if d, err := rec.DateField("date"); err == nil {
+		return d.first().Year, true
+	}
+	return 0, false
+}
+
+func parseYear(s string) (int, bool) {
+	for i := 0; i+4 <= len(s); i++ {
+		if isDigits(s[i:i+4]) && (i+4 == len(s) || !isDigit(s[i+4])) {
+			y, _ := strconv.Atoi(s[i : i+4])
+			return y, true
+		}
+		for i < len(s) && isDigit(s[i]) {
+			i++
+		}
+	}
+	return 0, false
+}
+
+var monthNames = [...]string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}
+
+// Month returns the month of rec, from 1 to 12, and whether it has one.
+// The month field may hold a number ("2", "02"), an abbreviation, with or
+// without a period ("feb", "Feb."), or an English name ("February"). Only
+// the first month of a range such as "Jan-Feb" counts. Without a month
+// field, the month is that of the date field, as for Year.
+func (rec *Record) Month() (int, bool) {
+	if m, ok := parseMonth(rec.firstField("month")); ok {
+		return m, true
+	}
+	if d, err := rec.DateField("date"); err == nil {
+		if p := d.first(); p.Precision >= PrecisionMonth && p.Month <= 12 {
+			return p.Month, true
+		}
+	}
+	return 0, false
+}
+
+func parseMonth(s string) (int, bool) {
+	s = strings.ToLower(strings.TrimLeft(s, "{ "))
+	if i := strings.IndexFunc(s, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) }); i >= 0 {
+		s = s[:i]
+	}
+	if n, err := strconv.Atoi(s); err == nil {
+		return n, n >= 1 && n <= 12
+	}
+	if len(s) < 3 {
+		return 0, false
+	}
+	for i, name := range monthNames {
+		if strings.HasPrefix(s, name) {
+			return i + 1, true
+		}
+	}
+	return 0, false
+}
+
+// Pages returns the first and last pages of rec and whether it has pages.
+// A single page or an article number, such as e0123456, is returned as
+// both first and last page. A last page that is abbreviated, as in
+// 1234-56, is written in full.
+func (rec *Record) Pages() (first, last string, ok bool) {
+	return parsePages(rec.firstField("pages"))
+}
+
+func parsePages(s string) (first, last string, ok bool) {
+	s = strings.TrimSpace(s)
+	for _, prefix := range []string{"pp.", "p."} {
+		s = strings.TrimSpace(strings.TrimPrefix(s, prefix))
+	}
+	if s == "" {
+		return "", "", false
+	}
+	isDash := func(r rune) bool { return r == '-' || r == '–' || r == '—' }
+	i := strings.IndexFunc(s, isDash)
+	if i < 0 {
+		return s, s, true
+	}
+	first = strings.TrimSpace(s[:i])
+	last = strings.TrimSpace(strings.TrimLeftFunc(s[i:], isDash))
+	if last == "" {
+		last = first
+	}
+	if isDigits(first) && isDigits(last) && len(last) < len(first) {
+		last = first[:len(first)-len(last)] + last
+	}
+	return first, last, first != ""
+}
+
+// Volume returns the volume of rec, as written.
+func (rec *Record) Volume() string {
+	return rec.firstField("volume")
+}
+
+// Issue returns the issue of rec, as written in the issue field or, if
+// there is none, in the number field.
+func (rec *Record) Issue() string {
+	return rec.firstField("issue", "number")
+}
+
+// eprint returns the eprint field of rec if its eprinttype is one of
+// types.
+func (rec *Record) eprint(types ...string) string {
+	typ := rec.firstField("eprinttype", "archiveprefix")
+	for _, t := range types {
+		if strings.EqualFold(typ, t) {
+			return rec.firstField("eprint")
+		}
+	}
+	return ""
+}
+
+// doiPattern matches a DOI, alone or inside a url or a note. Old DOIs
+// made of a SICI hold angle brackets, as in
+// 10.1002/(SICI)1097-0258(19980715)17:13<1495::AID-SIM863>3.0.CO;2-I.
+var doiPattern = regexp.MustCompile(`(?i)\b10\.[0-9]{4,9}/[^\s"{}]+`)
+
+// pmidPattern matches a PubMed identifier labelled as such in a note.
+var pmidPattern = regexp.MustCompile(`(?i)\bpmid:?\s*([0-9]+)`)
+
+// DOI returns the DOI of rec in lower case without a resolver or "doi:"
+// prefix, e.g. "10.1016/j.vaccine.2019.01.001", or "" if it has none. The
+// DOI is taken from the doi field, an eprint of type doi, or a DOI in the
+// url or note field.
+func (rec *Record) DOI() string {
+	for _, s := range []string{rec.firstField("doi"), rec.eprint("doi")} {
+		if doi := doiPattern.FindString(s); doi != "" {
+			return strings.ToLower(doi)
+		}
+	}
+	for _, s := range []string{rec.firstField("url"), rec.firstField("note")} {
+		if doi := findDOI(s); doi != "" {
+			return doi
+		}
+	}
+	return ""
+}
+
+// findDOI returns the first DOI in the text s in lower case, without the
+// punctuation that may follow it in a sentence or the angle bracket that
+// may close it, as in <https://doi.org/10.1000/xyz>.
+func findDOI(s string) string {
+	doi := doiPattern.FindString(s)
+	for {
+		trimmed := strings.TrimRight(doi, ".,;:)]")
+		if strings.HasSuffix(trimmed, ">") && strings.Count(trimmed, ">") > strings.Count(trimmed, "<") {
+			trimmed = trimmed[:len(trimmed)-1]
+		}
+		if trimmed == doi {
+			return strings.ToLower(doi)
+		}
+		doi = trimmed
+	}
+}
+
+// PMID returns the PubMed identifier of rec, or "" if it has none. It is
+// taken from the pmid field, an eprint of type pubmed, a PubMed url or a
+// "PMID:" label in the note field.
+func (rec *Record) PMID() string {
+	if s := strings.TrimPrefix(strings.ToLower(rec.firstField("pmid")), "pmid:"); isDigits(strings.TrimSpace(s)) {
+		return strings.TrimSpace(s)
+	}
+	if s := rec.eprint("pubmed", "pmid"); isDigits(s) {
+		return s
+	}
+	url := rec.firstField("url")
+	for _, prefix := range []string{"ncbi.nlm.nih.gov/pubmed/", "pubmed.ncbi.nlm.nih.gov/"} {
+		if i := strings.Index(url, prefix); i >= 0 {
+			s := strings.TrimRight(url[i+len(prefix):], "/")
+			if isDigits(s) {
+				return s
+			}
+		}
+	}
+	if m := pmidPattern.FindStringSubmatch(rec.firstField("note")); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+// ISBN returns the ISBN of rec as 13 digits without hyphens, converting an
+// ISBN-10, or "" if it has no valid ISBN. Only the first of several ISBNs
+// counts.
+func (rec *Record) ISBN() string {
+	s := identifier(rec.firstField("isbn"), 10, 13)
+	switch {
+	case len(s) == 10 && isbn10Valid(s):
+		s = "978" + s[:9]
+		return s + strconv.Itoa(isbn13Check(s))
+	case len(s) == 13 && isDigits(s) && isbn13Check(s[:12]) == int(s[12]-'0'):
+		return s
+	}
+	return ""
+}
+
+// identifier returns the first identifier in s, made of digits or X,
+// without the hyphens and spaces that separate its parts. A space ends it
+// once it has one of the lengths, so that of a list of identifiers
+// separated by spaces only the first is returned.
+func identifier(s string, lengths ...int) string {
+	if i := strings.IndexByte(s, ':'); i >= 0 && i < 10 {
+		s = s[i+1:] // a label such as "ISBN-10:"
+	}
+	longest := slices.Max(lengths)
+	var b []byte
+	for i := 0; i < len(s) && len(b) < longest; i++ {
+		switch c := s[i]; {
+		case isDigit(c) || c == 'X':
+			b = append(b, c)
+		case c == 'x':
+			b = append(b, 'X')
+		case c == '-' || c == ' ' && !slices.Contains(lengths, len(b)):
+		case len(b) > 0:
+			return string(b)
+		}
+	}
+	return string(b)
+}
+
+func isbn10Valid(s string) bool {
+	sum := 0
+	for i := 0; i < 10; i++ {
+		d := int(s[i] - '0')
+		if s[i] == 'X' && i == 9 {
+			d = 10
+		} else if !isDigit(s[i]) {
+			return false
+		}
+		sum += (10 - i) * d
+	}
+	return sum%11 == 0
+}
+
+// isbn13Check returns the check digit of the first 12 digits of an ISBN-13.
+func isbn13Check(s string) int {
+	sum := 0
+	for i := 0; i < 12; i++ {
+		d := int(s[i] - '0')
+		if i%2 == 1 {
+			d *= 3
+		}
+		sum += d
+	}
+	return (10 - sum%10) % 10
+}
+
+// ISSN returns the ISSN of rec in the form 1234-567X, or "" if it has no
+// valid ISSN. Only the first of several ISSNs counts.
+func (rec *Record) ISSN() string {
+	s := identifier(rec.firstField("issn"), 8)
+	if len(s) != 8 || !isDigits(s[:7]) {
+		return ""
+	}
+	sum := 0
+	for i := 0; i < 7; i++ {
+		sum += (8 - i) * int(s[i]-'0')
+	}
+	check := byte('0' + (11-sum%11)%11)
+	if check == '0'+10 {
+		check = 'X'
+	}
+	if s[7] != check {
+		return ""
+	}
+	return s[:4] + "-" + s[4:]
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// isDigits reports whether s is made of one or more digits.
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if !isDigit(s[i]) {
+			return false
+		}
+	}
+	return true
+}